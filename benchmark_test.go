@@ -1,9 +1,12 @@
 package mbserver
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -42,7 +45,7 @@ func serverClientSetup() (*serverClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	go setup.slave.Start()
+	go setup.slave.Start(context.Background())
 
 	// Wait for the server to start
 	time.Sleep(1 * time.Millisecond)
@@ -62,7 +65,7 @@ func serverClientSetup() (*serverClient, error) {
 
 func (setup *serverClient) Close() {
 	setup.clientTCPHandler.Close()
-	setup.slave.Shutdown()
+	setup.slave.Shutdown(context.Background())
 }
 
 func BenchmarkModbusWrite1968MultipleCoils(b *testing.B) {
@@ -140,6 +143,75 @@ func BenchmarkModbusRead125HoldingRegisters(b *testing.B) {
 	}
 }
 
+// slowRegister delays reads of holding registers, like a register backed by a
+// database or a field bus.
+type slowRegister struct {
+	Register
+	delay time.Duration
+}
+
+func (r slowRegister) ReadHoldingRegisters(start, count int) ([]uint16, Exception) {
+	time.Sleep(r.delay)
+	return r.Register.ReadHoldingRegisters(start, count)
+}
+
+// BenchmarkConcurrency compares the throughput of the execution models with
+// 1, 10 and 100 TCP clients reading from a slow register.
+func BenchmarkConcurrency(b *testing.B) {
+	modes := []struct {
+		name        string
+		concurrency Concurrency
+	}{
+		{"Serial", ConcurrencySerial},
+		{"PerConnection", ConcurrencyPerConnection},
+	}
+
+	for _, mode := range modes {
+		for _, clients := range []int{1, 10, 100} {
+			b.Run(fmt.Sprintf("%s/%dClients", mode.name, clients), func(b *testing.B) {
+				benchmarkClients(b, mode.concurrency, clients)
+			})
+		}
+	}
+}
+
+func benchmarkClients(b *testing.B, concurrency Concurrency, clients int) {
+	register := slowRegister{Register: NewSyncMemRegister(), delay: 100 * time.Microsecond}
+	s := NewServer(WithConcurrency(concurrency), WithRegister(register))
+	addr := getFreePort()
+	require.NoError(b, s.ListenTCP(addr))
+	go s.Start(context.Background())
+	defer s.Close()
+
+	handlers := make([]*modbus.TCPClientHandler, clients)
+	for i := range handlers {
+		handlers[i] = modbus.NewTCPClientHandler(addr)
+		require.NoError(b, handlers[i].Connect())
+		defer handlers[i].Close()
+	}
+
+	b.ResetTimer()
+
+	// The b.N requests are shared between the clients.
+	var sent atomic.Int64
+	var wg sync.WaitGroup
+	for _, handler := range handlers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			client := modbus.NewClient(handler)
+			for sent.Add(1) <= int64(b.N) {
+				if _, err := client.ReadHoldingRegisters(0, 10); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 // Start a Modbus server and use a client to write to and read from the serer.
 func Example() {
 	// Start the server.
@@ -149,8 +221,8 @@ func Example() {
 		log.Fatalln(err)
 		return
 	}
-	defer serv.Shutdown()
-	go serv.Start()
+	defer serv.Shutdown(context.Background())
+	go serv.Start(context.Background())
 
 	// Wait for the server to start
 	time.Sleep(1 * time.Millisecond)
@@ -227,8 +299,8 @@ func ExampleWithRegisterFunction() {
 		log.Fatalln(err)
 		return
 	}
-	defer serv.Shutdown()
-	go serv.Start()
+	defer serv.Shutdown(context.Background())
+	go serv.Start(context.Background())
 
 	// Wait for the server to start
 	time.Sleep(1 * time.Millisecond)
@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"log/slog"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/leijux/mbserver"
 )
@@ -25,9 +27,24 @@ func main() {
 		return
 	}
 
-	defer s.Shutdown()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
 
-	go s.Start()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
 
-	<-ctx.Done()
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			slog.Error("shutdown err", "err", err)
+		}
+	}()
+
+	if err := s.Start(context.Background()); !errors.Is(err, mbserver.ErrServerClosed) {
+		slog.Error("server err", "err", err)
+		return
+	}
+
+	// Start returns as soon as Shutdown begins, wait for the requests in flight.
+	<-shutdownDone
 }
@@ -0,0 +1,261 @@
+package mbserver
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnLimitPolicy selects what happens to a new TCP connection that would
+// exceed the limits set by WithMaxConns or WithMaxConnsPerIP.
+type ConnLimitPolicy int
+
+const (
+	// ConnLimitRefuse closes the new connection. It is the default.
+	ConnLimitRefuse ConnLimitPolicy = iota
+	// ConnLimitEvictOldest closes the connection that has been idle the
+	// longest, from the same remote IP when the per-IP limit is reached, and
+	// accepts the new one. Many PLCs behave this way.
+	ConnLimitEvictOldest
+)
+
+// WithIdleTimeout closes TCP connections on which nothing has been received
+// for the duration d. Zero, the default, keeps silent connections open.
+func WithIdleTimeout(d time.Duration) OptionFunc {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// WithMaxConns limits the number of TCP connections accepted at the same time
+// across all listeners. Zero, the default, means no limit.
+func WithMaxConns(n int) OptionFunc {
+	return func(s *Server) {
+		s.maxConns = n
+	}
+}
+
+// WithMaxConnsPerIP limits the number of TCP connections accepted at the same
+// time from a single remote IP address. Zero, the default, means no limit.
+func WithMaxConnsPerIP(n int) OptionFunc {
+	return func(s *Server) {
+		s.maxConnsPerIP = n
+	}
+}
+
+// WithConnLimitPolicy sets what happens when a connection limit is reached.
+func WithConnLimitPolicy(policy ConnLimitPolicy) OptionFunc {
+	return func(s *Server) {
+		s.connLimitPolicy = policy
+	}
+}
+
+// WithOnConnect sets a function called with each TCP connection before its
+// first request is read. It is called from the goroutine serving the
+// connection.
+func WithOnConnect(fn func(conn net.Conn)) OptionFunc {
+	return func(s *Server) {
+		s.onConnect = fn
+	}
+}
+
+// WithOnDisconnect sets a function called with each TCP connection once it
+// has been closed, whether by the peer, an idle timeout, an eviction or the
+// server shutting down.
+func WithOnDisconnect(fn func(conn net.Conn)) OptionFunc {
+	return func(s *Server) {
+		s.onDisconnect = fn
+	}
+}
+
+// connState is a TCP connection being served. Its Write method serializes
+// the responses written to the connection.
+type connState struct {
+	conn      net.Conn
+	ip        string
+	transport Transport
+	logger    *slog.Logger
+	// lastActive is the time, in Unix nanoseconds, data was last received.
+	lastActive atomic.Int64
+
+	writeMu      sync.Mutex
+	writeTimeout time.Duration
+	// broken is set once a response could not be written.
+	broken atomic.Bool
+}
+
+// newConnState returns the state of a connection accepted for transport, or
+// for TLS when the TCP connection is a TLS one.
+func (s *Server) newConnState(conn net.Conn, transport Transport) *connState {
+	if _, ok := conn.(*tls.Conn); ok && transport == TransportTCP {
+		transport = TransportTLS
+	}
+
+	c := &connState{
+		conn:         conn,
+		ip:           remoteIP(conn.RemoteAddr()),
+		transport:    transport,
+		logger:       s.connLogger(transport, conn.RemoteAddr()),
+		writeTimeout: s.writeTimeout,
+	}
+	c.touch()
+	return c
+}
+
+func (c *connState) Read(b []byte) (int, error) {
+	return c.conn.Read(b)
+}
+
+// Write writes a whole response, never interleaved with another one, within
+// the write timeout.
+func (c *connState) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.writeTimeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.conn.Write(b)
+}
+
+func (c *connState) Close() error {
+	return c.conn.Close()
+}
+
+// touch records that data has been received.
+func (c *connState) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+func (c *connState) lastActivity() time.Time {
+	return time.Unix(0, c.lastActive.Load())
+}
+
+// remoteIP returns the IP address of addr, or its string form when it has no
+// host part, as with Unix domain sockets.
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
+
+// readDeadline returns the deadline of the next read on c: the end of the
+// idle timeout, or none without one.
+func (s *Server) readDeadline(c *connState) time.Time {
+	if s.idleTimeout <= 0 {
+		return time.Time{}
+	}
+	return c.lastActivity().Add(s.idleTimeout)
+}
+
+// idle reports whether c has been silent for longer than the idle timeout.
+func (s *Server) idle(c *connState) bool {
+	return s.idleTimeout > 0 && time.Since(c.lastActivity()) >= s.idleTimeout
+}
+
+// admit applies the connection limits to a newly accepted connection. It
+// reports false if the connection must be refused; with ConnLimitEvictOldest
+// it closes the connections making room for it instead.
+func (s *Server) admit(conn net.Conn, transport Transport) (*connState, bool) {
+	c := s.newConnState(conn, transport)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var evicted []*connState
+
+	if s.maxConnsPerIP > 0 && s.connsPerIP[c.ip] >= s.maxConnsPerIP {
+		if s.connLimitPolicy != ConnLimitEvictOldest {
+			c.logger.Warn("connection refused, per-IP limit reached", "limit", s.maxConnsPerIP)
+			return nil, false
+		}
+		evicted = append(evicted, s.evictIdlest(func(o *connState) bool { return o.ip == c.ip }))
+	}
+	if s.maxConns > 0 && len(s.tcpConns) >= s.maxConns {
+		if s.connLimitPolicy != ConnLimitEvictOldest {
+			c.logger.Warn("connection refused, connection limit reached", "limit", s.maxConns)
+			return nil, false
+		}
+		evicted = append(evicted, s.evictIdlest(func(*connState) bool { return true }))
+	}
+
+	for _, victim := range evicted {
+		if victim != nil {
+			victim.logger.Warn("connection evicted", "idle", time.Since(victim.lastActivity()))
+			victim.conn.Close()
+		}
+	}
+
+	if s.tcpConns == nil {
+		s.tcpConns = make(map[*connState]struct{})
+		s.connsPerIP = make(map[string]int)
+	}
+	s.tcpConns[c] = struct{}{}
+	s.connsPerIP[c.ip]++
+	return c, true
+}
+
+// evictIdlest forgets the connection idle the longest among those matching
+// and returns it so that it can be closed. s.mu must be held.
+func (s *Server) evictIdlest(matching func(*connState) bool) *connState {
+	var victim *connState
+	for c := range s.tcpConns {
+		if !matching(c) {
+			continue
+		}
+		if victim == nil || c.lastActive.Load() < victim.lastActive.Load() {
+			victim = c
+		}
+	}
+	if victim != nil {
+		s.forgetLocked(victim)
+	}
+	return victim
+}
+
+// forget removes c from the connections counted in the limits.
+func (s *Server) forget(c *connState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.forgetLocked(c)
+}
+
+func (s *Server) forgetLocked(c *connState) {
+	if _, ok := s.tcpConns[c]; !ok {
+		return
+	}
+	delete(s.tcpConns, c)
+	if s.connsPerIP[c.ip]--; s.connsPerIP[c.ip] <= 0 {
+		delete(s.connsPerIP, c.ip)
+	}
+}
+
+// runTCPConn serves c with serve, calling the connect and disconnect hooks
+// around it, and closes the connection.
+func (s *Server) runTCPConn(c *connState, serve func(*connState)) {
+	defer s.forget(c)
+
+	s.metrics.tcpConns.Add(1)
+	defer s.metrics.tcpConns.Add(-1)
+
+	if s.onConnect != nil {
+		s.onConnect(c.conn)
+	}
+
+	serve(c)
+	c.conn.Close()
+
+	if s.onDisconnect != nil {
+		s.onDisconnect(c.conn)
+	}
+}
@@ -0,0 +1,155 @@
+package mbserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTCPServer starts s on a local TCP port and returns its address.
+func startTCPServer(t *testing.T, s *Server) string {
+	t.Helper()
+
+	require.NoError(t, s.ListenTCP("127.0.0.1:0"))
+	address := s.listeners[0].Addr().String()
+
+	go s.Start(context.Background())
+	t.Cleanup(func() { s.Close() })
+
+	return address
+}
+
+// readHoldingRegister sends a Read Holding Registers request on conn and
+// reports whether a response was received.
+func readHoldingRegister(conn net.Conn) bool {
+	request := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	if _, err := conn.Write(request); err != nil {
+		return false
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response := make([]byte, 11)
+	_, err := io.ReadFull(conn, response)
+	return err == nil
+}
+
+// closedByServer reports whether the server closed conn.
+func closedByServer(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	return err == io.EOF
+}
+
+func TestIdleTimeout(t *testing.T) {
+	var disconnected atomic.Int32
+	s := NewServer(
+		WithIdleTimeout(100*time.Millisecond),
+		WithOnDisconnect(func(net.Conn) { disconnected.Add(1) }),
+	)
+	address := startTCPServer(t, s)
+
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Requests keep the connection open.
+	for range 3 {
+		time.Sleep(50 * time.Millisecond)
+		require.True(t, readHoldingRegister(conn))
+	}
+
+	assert.True(t, closedByServer(conn))
+	assert.Eventually(t, func() bool { return disconnected.Load() == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestConnLimits(t *testing.T) {
+	t.Run("refuses connections over the limit", func(t *testing.T) {
+		s := NewServer(WithMaxConns(1))
+		address := startTCPServer(t, s)
+
+		first, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+		defer first.Close()
+		require.True(t, readHoldingRegister(first))
+
+		second, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+		defer second.Close()
+		assert.True(t, closedByServer(second))
+
+		assert.True(t, readHoldingRegister(first))
+	})
+
+	t.Run("refuses connections over the per-IP limit", func(t *testing.T) {
+		s := NewServer(WithMaxConnsPerIP(2))
+		address := startTCPServer(t, s)
+
+		for range 2 {
+			conn, err := net.Dial("tcp", address)
+			require.NoError(t, err)
+			defer conn.Close()
+			require.True(t, readHoldingRegister(conn))
+		}
+
+		conn, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.True(t, closedByServer(conn))
+	})
+
+	t.Run("evicts the connection idle the longest", func(t *testing.T) {
+		s := NewServer(WithMaxConns(2), WithConnLimitPolicy(ConnLimitEvictOldest))
+		address := startTCPServer(t, s)
+
+		oldest, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+		defer oldest.Close()
+		require.True(t, readHoldingRegister(oldest))
+
+		idlest, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+		defer idlest.Close()
+		require.True(t, readHoldingRegister(idlest))
+
+		// The oldest connection becomes the most recently active.
+		time.Sleep(20 * time.Millisecond)
+		require.True(t, readHoldingRegister(oldest))
+
+		newest, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+		defer newest.Close()
+		require.True(t, readHoldingRegister(newest))
+
+		assert.True(t, closedByServer(idlest))
+		assert.True(t, readHoldingRegister(oldest))
+	})
+}
+
+func TestConnCallbacks(t *testing.T) {
+	var connected, disconnected atomic.Int32
+	s := NewServer(
+		WithOnConnect(func(conn net.Conn) {
+			assert.NotNil(t, conn.RemoteAddr())
+			connected.Add(1)
+		}),
+		WithOnDisconnect(func(net.Conn) { disconnected.Add(1) }),
+	)
+	address := startTCPServer(t, s)
+
+	for range 3 {
+		conn, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+		require.True(t, readHoldingRegister(conn))
+		conn.Close()
+	}
+
+	assert.Eventually(t, func() bool {
+		return connected.Load() == 3 && disconnected.Load() == 3
+	}, time.Second, 10*time.Millisecond)
+}
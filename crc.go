@@ -52,3 +52,13 @@ func crc16IBM(data []byte) uint16 {
 
 	return crc
 }
+
+// lrc returns the longitudinal redundancy check of data, the two's complement
+// of the sum of the bytes, used by Modbus ASCII.
+func lrc(data []byte) uint8 {
+	var sum uint8
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
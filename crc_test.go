@@ -12,3 +12,10 @@ func TestCRC(t *testing.T) {
 
 	assert.EqualValues(t, expect, got)
 }
+
+func TestLRC(t *testing.T) {
+	got := lrc([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01})
+	expect := 0xFB
+
+	assert.EqualValues(t, expect, got)
+}
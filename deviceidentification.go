@@ -0,0 +1,152 @@
+package mbserver
+
+import "sort"
+
+// meiReadDeviceIdentification is the MEI type of Read Device Identification.
+const meiReadDeviceIdentification = 0x0E
+
+// Read device ID codes.
+const (
+	readDeviceIDBasic      = 0x01
+	readDeviceIDRegular    = 0x02
+	readDeviceIDExtended   = 0x03
+	readDeviceIDIndividual = 0x04
+)
+
+// maxDeviceObjectsLength is the room left for objects in a response PDU once
+// the function code and the six byte MEI header are accounted for.
+const maxDeviceObjectsLength = 253 - 1 - 6
+
+// DeviceIdentification holds the objects returned by Read Device Identification
+// (function 43 / MEI type 14).
+type DeviceIdentification struct {
+	// Basic objects 0x00 to 0x02, always reported.
+	VendorName         string
+	ProductCode        string
+	MajorMinorRevision string
+
+	// Regular objects 0x03 to 0x06, reported when not empty.
+	VendorURL           string
+	ProductName         string
+	ModelName           string
+	UserApplicationName string
+
+	// Extended holds the private objects 0x80 to 0xFF. Keys below 0x80 are ignored.
+	Extended map[uint8][]byte
+}
+
+type deviceObject struct {
+	id    uint8
+	value []byte
+}
+
+// WithDeviceIdentification enables function 43 / MEI type 14 and sets the
+// identity objects reported by the server. Object values longer than the
+// space available in a single response are truncated.
+func WithDeviceIdentification(identification DeviceIdentification) OptionFunc {
+	return func(s *Server) {
+		s.deviceObjects, s.conformityLevel = identification.objects()
+		s.function[43] = AdaptFunction(s.readDeviceIdentification)
+	}
+}
+
+// objects returns the configured objects sorted by id and the conformity level
+// they correspond to.
+func (d DeviceIdentification) objects() ([]deviceObject, uint8) {
+	objects := []deviceObject{
+		{0x00, []byte(d.VendorName)},
+		{0x01, []byte(d.ProductCode)},
+		{0x02, []byte(d.MajorMinorRevision)},
+	}
+	level := uint8(readDeviceIDBasic)
+
+	for i, value := range []string{d.VendorURL, d.ProductName, d.ModelName, d.UserApplicationName} {
+		if value != "" {
+			objects = append(objects, deviceObject{uint8(0x03 + i), []byte(value)})
+			level = readDeviceIDRegular
+		}
+	}
+
+	for id, value := range d.Extended {
+		if id >= 0x80 {
+			objects = append(objects, deviceObject{id, value})
+			level = readDeviceIDExtended
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].id < objects[j].id })
+
+	for i := range objects {
+		if len(objects[i].value) > maxDeviceObjectsLength-2 {
+			objects[i].value = objects[i].value[:maxDeviceObjectsLength-2]
+		}
+	}
+
+	// Individual access is always supported.
+	return objects, 0x80 | level
+}
+
+// readDeviceIdentification function 43 / MEI type 14, reads the device identification objects.
+func (s *Server) readDeviceIdentification(_ Register, frame Framer) ([]byte, Exception) {
+	data := frame.GetData()
+	if len(data) < 1 || data[0] != meiReadDeviceIdentification {
+		return []byte{}, IllegalFunction
+	}
+	if len(data) != 3 {
+		return []byte{}, IllegalDataValue
+	}
+
+	readCode, objectID := data[1], data[2]
+
+	var last uint8
+	switch readCode {
+	case readDeviceIDBasic:
+		last = 0x02
+	case readDeviceIDRegular:
+		last = 0x7F
+	case readDeviceIDExtended:
+		last = 0xFF
+	case readDeviceIDIndividual:
+		for _, object := range s.deviceObjects {
+			if object.id == objectID {
+				response := []byte{meiReadDeviceIdentification, readCode, s.conformityLevel, 0x00, 0x00, 1}
+				response = append(response, object.id, byte(len(object.value)))
+				return append(response, object.value...), Success
+			}
+		}
+		return []byte{}, IllegalDataAddress
+	default:
+		return []byte{}, IllegalDataValue
+	}
+
+	// A stream access starting at an unknown object restarts at the beginning.
+	start := -1
+	for i, object := range s.deviceObjects {
+		if object.id == objectID && object.id <= last {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	response := []byte{meiReadDeviceIdentification, readCode, s.conformityLevel, 0x00, 0x00, 0}
+	length := 0
+	for _, object := range s.deviceObjects[start:] {
+		if object.id > last {
+			break
+		}
+		if length+2+len(object.value) > maxDeviceObjectsLength {
+			response[3] = 0xFF
+			response[4] = object.id
+			break
+		}
+		response = append(response, object.id, byte(len(object.value)))
+		response = append(response, object.value...)
+		response[5]++
+		length += 2 + len(object.value)
+	}
+
+	return response, Success
+}
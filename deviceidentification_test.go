@@ -0,0 +1,144 @@
+package mbserver
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDeviceIdentificationServer() *Server {
+	return NewServer(WithDeviceIdentification(DeviceIdentification{
+		VendorName:         "leijux",
+		ProductCode:        "MB",
+		MajorMinorRevision: "1.0",
+		ProductName:        "mbserver",
+		Extended: map[uint8][]byte{
+			0x80: []byte("x"),
+			0x10: []byte("ignored"),
+		},
+	}))
+}
+
+func TestReadDeviceIdentificationDisabled(t *testing.T) {
+	s := NewServer()
+
+	frame := newTestTCPFrame(43)
+	frame.SetData([]byte{0x0E, 0x01, 0x00})
+
+	response := s.handle(&Request{frame: frame})
+	assert.Equal(t, IllegalFunction, GetException(response))
+}
+
+func TestReadDeviceIdentification(t *testing.T) {
+	s := newTestDeviceIdentificationServer()
+
+	tests := []struct {
+		name   string
+		data   []byte
+		expect []byte
+	}{
+		{
+			name: "basic stream",
+			data: []byte{0x0E, 0x01, 0x00},
+			expect: []byte{0x0E, 0x01, 0x83, 0x00, 0x00, 3,
+				0x00, 6, 'l', 'e', 'i', 'j', 'u', 'x',
+				0x01, 2, 'M', 'B',
+				0x02, 3, '1', '.', '0'},
+		},
+		{
+			name: "regular stream",
+			data: []byte{0x0E, 0x02, 0x02},
+			expect: []byte{0x0E, 0x02, 0x83, 0x00, 0x00, 2,
+				0x02, 3, '1', '.', '0',
+				0x04, 8, 'm', 'b', 's', 'e', 'r', 'v', 'e', 'r'},
+		},
+		{
+			name: "extended stream",
+			data: []byte{0x0E, 0x03, 0x04},
+			expect: []byte{0x0E, 0x03, 0x83, 0x00, 0x00, 2,
+				0x04, 8, 'm', 'b', 's', 'e', 'r', 'v', 'e', 'r',
+				0x80, 1, 'x'},
+		},
+		{
+			name: "unknown object restarts stream",
+			data: []byte{0x0E, 0x01, 0x05},
+			expect: []byte{0x0E, 0x01, 0x83, 0x00, 0x00, 3,
+				0x00, 6, 'l', 'e', 'i', 'j', 'u', 'x',
+				0x01, 2, 'M', 'B',
+				0x02, 3, '1', '.', '0'},
+		},
+		{
+			name:   "individual",
+			data:   []byte{0x0E, 0x04, 0x80},
+			expect: []byte{0x0E, 0x04, 0x83, 0x00, 0x00, 1, 0x80, 1, 'x'},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := newTestTCPFrame(43)
+			frame.SetData(tt.data)
+
+			response := s.handle(&Request{frame: frame})
+			assertSuccess(t, response)
+			assert.Equal(t, tt.expect, response.GetData())
+		})
+	}
+}
+
+func TestReadDeviceIdentificationExceptions(t *testing.T) {
+	s := newTestDeviceIdentificationServer()
+
+	tests := []struct {
+		name   string
+		data   []byte
+		expect Exception
+	}{
+		{"unsupported MEI type", []byte{0x0D, 0x01, 0x00}, IllegalFunction},
+		{"invalid read device ID code", []byte{0x0E, 0x05, 0x00}, IllegalDataValue},
+		{"short request", []byte{0x0E, 0x01}, IllegalDataValue},
+		{"unknown individual object", []byte{0x0E, 0x04, 0x81}, IllegalDataAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := newTestTCPFrame(43)
+			frame.SetData(tt.data)
+
+			response := s.handle(&Request{frame: frame})
+			require.Equal(t, tt.expect, GetException(response))
+		})
+	}
+}
+
+func TestReadDeviceIdentificationMoreFollows(t *testing.T) {
+	s := NewServer(WithDeviceIdentification(DeviceIdentification{
+		VendorName:         "leijux",
+		ProductCode:        "MB",
+		MajorMinorRevision: "1.0",
+		Extended: map[uint8][]byte{
+			0x80: bytes.Repeat([]byte{'a'}, 200),
+			0x81: bytes.Repeat([]byte{'b'}, 200),
+		},
+	}))
+
+	frame := newTestTCPFrame(43)
+	frame.SetData([]byte{0x0E, 0x03, 0x00})
+
+	response := s.handle(&Request{frame: frame})
+	assertSuccess(t, response)
+
+	data := response.GetData()
+	assert.Equal(t, []byte{0x0E, 0x03, 0x83, 0xFF, 0x81, 4}, data[:6])
+	assert.LessOrEqual(t, len(data), 252)
+
+	frame.SetData([]byte{0x0E, 0x03, 0x81})
+
+	response = s.handle(&Request{frame: frame})
+	assertSuccess(t, response)
+
+	data = response.GetData()
+	assert.Equal(t, []byte{0x0E, 0x03, 0x83, 0x00, 0x00, 1, 0x81, 200}, data[:8])
+}
@@ -0,0 +1,348 @@
+package mbserver
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// Diagnostics sub-function codes (function 8).
+const (
+	diagnosticReturnQueryData              = 0x00
+	diagnosticRestartCommunications        = 0x01
+	diagnosticReturnDiagnosticRegister     = 0x02
+	diagnosticForceListenOnlyMode          = 0x04
+	diagnosticClearCounters                = 0x0A
+	diagnosticReturnBusMessageCount        = 0x0B
+	diagnosticReturnBusCommunicationErrors = 0x0C
+	diagnosticReturnBusExceptionErrors     = 0x0D
+	diagnosticReturnServerMessageCount     = 0x0E
+	diagnosticReturnServerNoResponseCount  = 0x0F
+	diagnosticReturnServerNAKCount         = 0x10
+	diagnosticReturnServerBusyCount        = 0x11
+	diagnosticReturnBusCharacterOverruns   = 0x12
+	diagnosticClearOverrunCounter          = 0x14
+)
+
+// Communication event log bytes.
+const (
+	eventCommunicationRestart = 0x00
+	eventEnteredListenOnly    = 0x04
+
+	eventReceive            = 0x80
+	eventReceiveCommError   = 0x02
+	eventReceiveCharOverrun = 0x10
+	eventReceiveListenOnly  = 0x20
+	eventReceiveBroadcast   = 0x40
+	eventSend               = 0x40
+	eventSendReadException  = 0x01
+	eventSendServerAbort    = 0x02
+	eventSendServerBusy     = 0x04
+	eventSendServerNAK      = 0x08
+	eventSendListenOnly     = 0x20
+)
+
+// maxCommunicationEventLogLen is the number of events kept in the communication event log.
+const maxCommunicationEventLogLen = 64
+
+// DiagnosticCounters is a snapshot of the counters returned by the Diagnostics
+// (function 8) and Get Comm Event Counter (function 11) requests.
+type DiagnosticCounters struct {
+	BusMessages            uint16
+	BusCommunicationErrors uint16
+	BusExceptionErrors     uint16
+	ServerMessages         uint16
+	ServerNoResponses      uint16
+	ServerNAKs             uint16
+	ServerBusy             uint16
+	BusCharacterOverruns   uint16
+	CommEvents             uint16
+}
+
+// Diagnostics keeps the serial line counters, the listen only mode and the
+// communication event log of a link. Each serial port has its own Diagnostics
+// whose counters are also accumulated in the server wide Diagnostics.
+//
+// Network transports have no diagnostics: their requests are not counted and
+// the diagnostic function codes 8, 11 and 12 are answered with
+// IllegalFunction. A nil *Diagnostics stands for them.
+type Diagnostics struct {
+	mu     sync.Mutex
+	parent *Diagnostics
+
+	counters   DiagnosticCounters
+	listenOnly bool
+	// events holds the communication event log, most recent event first.
+	events []byte
+}
+
+func newDiagnostics(parent *Diagnostics) *Diagnostics {
+	return &Diagnostics{parent: parent}
+}
+
+// Counters returns a snapshot of the diagnostic counters.
+func (d *Diagnostics) Counters() DiagnosticCounters {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.counters
+}
+
+// ListenOnly reports whether the link is in listen only mode.
+func (d *Diagnostics) ListenOnly() bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.listenOnly
+}
+
+// Events returns a copy of the communication event log, most recent event first.
+func (d *Diagnostics) Events() []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]byte(nil), d.events...)
+}
+
+// update applies fn to d and to the server wide diagnostics it reports to.
+func (d *Diagnostics) update(fn func(*Diagnostics)) {
+	for ; d != nil; d = d.parent {
+		d.mu.Lock()
+		fn(d)
+		d.mu.Unlock()
+	}
+}
+
+func (d *Diagnostics) logEvent(event byte) {
+	if len(d.events) < maxCommunicationEventLogLen {
+		d.events = append(d.events, 0)
+	}
+	copy(d.events[1:], d.events)
+	d.events[0] = event
+}
+
+// communicationError records a frame dropped because of a CRC or LRC error.
+func (d *Diagnostics) communicationError() {
+	d.update(func(d *Diagnostics) {
+		d.counters.BusCommunicationErrors++
+		event := byte(eventReceive | eventReceiveCommError)
+		if d.listenOnly {
+			event |= eventReceiveListenOnly
+		}
+		d.logEvent(event)
+	})
+}
+
+// characterOverrun records a frame dropped because it exceeded the maximum frame length.
+func (d *Diagnostics) characterOverrun() {
+	d.update(func(d *Diagnostics) {
+		d.counters.BusCharacterOverruns++
+		event := byte(eventReceive | eventReceiveCharOverrun)
+		if d.listenOnly {
+			event |= eventReceiveListenOnly
+		}
+		d.logEvent(event)
+	})
+}
+
+// received records a request addressed to the server and reports whether the
+// link was in listen only mode when it arrived.
+func (d *Diagnostics) received(broadcast bool) (listenOnly bool) {
+	listenOnly = d.ListenOnly()
+
+	d.update(func(d *Diagnostics) {
+		d.counters.BusMessages++
+		d.counters.ServerMessages++
+		event := byte(eventReceive)
+		if d.listenOnly {
+			event |= eventReceiveListenOnly
+		}
+		if broadcast {
+			event |= eventReceiveBroadcast
+		}
+		d.logEvent(event)
+	})
+
+	return listenOnly
+}
+
+// notAddressed records a request seen on the link but addressed to a unit the
+// server does not host.
+func (d *Diagnostics) notAddressed() {
+	d.update(func(d *Diagnostics) {
+		d.counters.BusMessages++
+	})
+}
+
+// completed records the outcome of a request.
+func (d *Diagnostics) completed(funcCode uint8, exception Exception, responded bool) {
+	d.update(func(d *Diagnostics) {
+		event := byte(eventSend)
+
+		switch exception {
+		case Success:
+			// Get Comm Event Counter does not count as a communication event.
+			if funcCode != 11 {
+				d.counters.CommEvents++
+			}
+		case IllegalFunction, IllegalDataAddress, IllegalDataValue:
+			event |= eventSendReadException
+		case SlaveDeviceFailure:
+			event |= eventSendServerAbort
+		case AcknowledgeSlave, SlaveDeviceBusy:
+			event |= eventSendServerBusy
+		case NegativeAcknowledge:
+			event |= eventSendServerNAK
+		}
+
+		if exception != Success && responded {
+			d.counters.BusExceptionErrors++
+		}
+		switch exception {
+		case SlaveDeviceBusy:
+			d.counters.ServerBusy++
+		case NegativeAcknowledge:
+			d.counters.ServerNAKs++
+		}
+
+		if !responded {
+			d.counters.ServerNoResponses++
+		}
+		if d.listenOnly {
+			event |= eventSendListenOnly
+		}
+		d.logEvent(event)
+	})
+}
+
+// function returns the built-in handler of the diagnostic function codes.
+func (d *Diagnostics) function(funcCode uint8) Function {
+	if d == nil {
+		return nil
+	}
+	switch funcCode {
+	case 8:
+		return d.diagnostic
+	case 11:
+		return d.getCommEventCounter
+	case 12:
+		return d.getCommEventLog
+	}
+	return nil
+}
+
+// isRestartCommunications reports whether frame is the only request that is
+// processed in listen only mode.
+func isRestartCommunications(frame Framer) bool {
+	data := frame.GetData()
+	return frame.GetFunction() == 8 && len(data) >= 2 &&
+		binary.BigEndian.Uint16(data[0:2]) == diagnosticRestartCommunications
+}
+
+// diagnostic function 8, serial line diagnostics.
+func (d *Diagnostics) diagnostic(_ Register, frame Framer) ([]byte, Exception) {
+	data := frame.GetData()
+	if len(data) < 2 {
+		return []byte{}, IllegalDataValue
+	}
+
+	subFunction := binary.BigEndian.Uint16(data[0:2])
+	if subFunction == diagnosticReturnQueryData {
+		return data, Success
+	}
+
+	if len(data) != 4 {
+		return []byte{}, IllegalDataValue
+	}
+	value := binary.BigEndian.Uint16(data[2:4])
+
+	if subFunction == diagnosticRestartCommunications {
+		if value != 0x0000 && value != 0xFF00 {
+			return []byte{}, IllegalDataValue
+		}
+
+		d.mu.Lock()
+		d.listenOnly = false
+		d.counters = DiagnosticCounters{}
+		if value == 0xFF00 {
+			d.events = nil
+		}
+		d.logEvent(eventCommunicationRestart)
+		d.mu.Unlock()
+
+		return data, Success
+	}
+
+	if value != 0x0000 {
+		return []byte{}, IllegalDataValue
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var counter uint16
+	switch subFunction {
+	case diagnosticReturnDiagnosticRegister:
+		// The diagnostic register is not used and always reads zero.
+	case diagnosticForceListenOnlyMode:
+		d.listenOnly = true
+		d.logEvent(eventEnteredListenOnly)
+	case diagnosticClearCounters:
+		d.counters = DiagnosticCounters{}
+	case diagnosticReturnBusMessageCount:
+		counter = d.counters.BusMessages
+	case diagnosticReturnBusCommunicationErrors:
+		counter = d.counters.BusCommunicationErrors
+	case diagnosticReturnBusExceptionErrors:
+		counter = d.counters.BusExceptionErrors
+	case diagnosticReturnServerMessageCount:
+		counter = d.counters.ServerMessages
+	case diagnosticReturnServerNoResponseCount:
+		counter = d.counters.ServerNoResponses
+	case diagnosticReturnServerNAKCount:
+		counter = d.counters.ServerNAKs
+	case diagnosticReturnServerBusyCount:
+		counter = d.counters.ServerBusy
+	case diagnosticReturnBusCharacterOverruns:
+		counter = d.counters.BusCharacterOverruns
+	case diagnosticClearOverrunCounter:
+		d.counters.BusCharacterOverruns = 0
+	default:
+		return []byte{}, IllegalFunction
+	}
+
+	response := make([]byte, 4)
+	binary.BigEndian.PutUint16(response[0:2], subFunction)
+	binary.BigEndian.PutUint16(response[2:4], counter)
+	return response, Success
+}
+
+// getCommEventCounter function 11, returns the status word and the event counter.
+func (d *Diagnostics) getCommEventCounter(_ Register, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) != 0 {
+		return []byte{}, IllegalDataValue
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	response := make([]byte, 4)
+	binary.BigEndian.PutUint16(response[2:4], d.counters.CommEvents)
+	return response, Success
+}
+
+// getCommEventLog function 12, returns the status word, the event counter,
+// the message count and the communication event log.
+func (d *Diagnostics) getCommEventLog(_ Register, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) != 0 {
+		return []byte{}, IllegalDataValue
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	response := make([]byte, 7, 7+len(d.events))
+	response[0] = byte(6 + len(d.events))
+	binary.BigEndian.PutUint16(response[3:5], d.counters.CommEvents)
+	binary.BigEndian.PutUint16(response[5:7], d.counters.BusMessages)
+	return append(response, d.events...), Success
+}
@@ -0,0 +1,179 @@
+package mbserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDiagnosticFrame(subFunction, value uint16) *TCPFrame {
+	frame := newTestTCPFrame(8)
+	SetDataWithRegisterAndNumber(frame, subFunction, value)
+	return frame
+}
+
+func TestDiagnosticReturnQueryData(t *testing.T) {
+	s := NewServer()
+	link := newDiagnostics(s.diagnostics)
+
+	frame := newTestTCPFrame(8)
+	frame.SetData([]byte{0, 0, 0xA5, 0x37, 0x01})
+
+	response := s.handle(&Request{frame: frame, diagnostics: link})
+	assertSuccess(t, response)
+	assert.Equal(t, []byte{0, 0, 0xA5, 0x37, 0x01}, response.GetData())
+}
+
+func TestDiagnosticCounters(t *testing.T) {
+	s := NewServer()
+	link := newDiagnostics(s.diagnostics)
+
+	// One successful request and one exception.
+	read := newTestTCPFrame(3)
+	SetDataWithRegisterAndNumber(read, 0, 1)
+	assertSuccess(t, s.handle(&Request{frame: read, diagnostics: link}))
+
+	illegal := newTestTCPFrame(0x41)
+	require.Equal(t, IllegalFunction, GetException(s.handle(&Request{frame: illegal, diagnostics: link})))
+
+	tests := []struct {
+		name        string
+		subFunction uint16
+		expect      uint16
+	}{
+		{"bus message count", 0x0B, 3},
+		{"bus communication errors", 0x0C, 0},
+		{"bus exception errors", 0x0D, 1},
+		{"server message count", 0x0E, 6},
+		{"server no response count", 0x0F, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := s.handle(&Request{frame: newTestDiagnosticFrame(tt.subFunction, 0), diagnostics: link})
+			assertSuccess(t, response)
+			assert.Equal(t, []byte{0, byte(tt.subFunction), byte(tt.expect >> 8), byte(tt.expect)}, response.GetData())
+		})
+	}
+
+	response := s.handle(&Request{frame: newTestDiagnosticFrame(0x0A, 0), diagnostics: link})
+	assertSuccess(t, response)
+	// Only the clear request itself has been counted since.
+	assert.Equal(t, DiagnosticCounters{CommEvents: 1}, link.Counters())
+	assert.Equal(t, uint16(8), s.Diagnostics().Counters().ServerMessages)
+}
+
+func TestDiagnosticExceptions(t *testing.T) {
+	s := NewServer()
+	link := newDiagnostics(s.diagnostics)
+
+	tests := []struct {
+		name   string
+		frame  *TCPFrame
+		expect Exception
+	}{
+		{"unsupported sub-function", newTestDiagnosticFrame(0x03, 0), IllegalFunction},
+		{"non zero data", newTestDiagnosticFrame(0x0B, 1), IllegalDataValue},
+		{"invalid restart option", newTestDiagnosticFrame(0x01, 1), IllegalDataValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := s.handle(&Request{frame: tt.frame, diagnostics: link})
+			require.Equal(t, tt.expect, GetException(response))
+		})
+	}
+}
+
+func TestDiagnosticListenOnlyMode(t *testing.T) {
+	mr := NewMemRegister()
+	s := NewServer(WithRegister(mr))
+	diagnostics := newDiagnostics(s.diagnostics)
+
+	response := s.handle(&Request{frame: newTestDiagnosticFrame(0x04, 0), diagnostics: diagnostics})
+	assert.Nil(t, response)
+	assert.True(t, diagnostics.ListenOnly())
+	assert.False(t, s.Diagnostics().ListenOnly())
+
+	// Requests are neither processed nor answered.
+	write := newTestTCPFrame(6)
+	SetDataWithRegisterAndNumber(write, 1, 1)
+	assert.Nil(t, s.handle(&Request{frame: write, diagnostics: diagnostics}))
+	assert.Equal(t, uint16(0), mr.HoldingRegisters[1])
+
+	// Restart communications leaves listen only mode without answering.
+	response = s.handle(&Request{frame: newTestDiagnosticFrame(0x01, 0), diagnostics: diagnostics})
+	assert.Nil(t, response)
+	assert.False(t, diagnostics.ListenOnly())
+
+	response = s.handle(&Request{frame: write, diagnostics: diagnostics})
+	assertSuccess(t, response)
+	assert.Equal(t, uint16(1), mr.HoldingRegisters[1])
+
+	// The restart only cleared the serial port counters and was itself not answered.
+	assert.Equal(t, uint16(1), diagnostics.Counters().ServerNoResponses)
+	assert.Equal(t, uint16(3), s.Diagnostics().Counters().ServerNoResponses)
+}
+
+func TestGetCommEventCounter(t *testing.T) {
+	s := NewServer()
+	link := newDiagnostics(s.diagnostics)
+
+	read := newTestTCPFrame(3)
+	SetDataWithRegisterAndNumber(read, 0, 1)
+	assertSuccess(t, s.handle(&Request{frame: read, diagnostics: link}))
+	assertSuccess(t, s.handle(&Request{frame: read, diagnostics: link}))
+
+	// Get Comm Event Counter itself is not counted.
+	assertSuccess(t, s.handle(&Request{frame: newTestTCPFrame(11), diagnostics: link}))
+
+	response := s.handle(&Request{frame: newTestTCPFrame(11), diagnostics: link})
+	assertSuccess(t, response)
+	assert.Equal(t, []byte{0, 0, 0, 2}, response.GetData())
+}
+
+func TestGetCommEventLog(t *testing.T) {
+	s := NewServer()
+	link := newDiagnostics(s.diagnostics)
+
+	illegal := newTestTCPFrame(0x41)
+	require.Equal(t, IllegalFunction, GetException(s.handle(&Request{frame: illegal, diagnostics: link})))
+
+	response := s.handle(&Request{frame: newTestTCPFrame(12), diagnostics: link})
+	assertSuccess(t, response)
+
+	// Receive and send events of the failed request, then the receive event of this one.
+	expect := []byte{9, 0, 0, 0, 0, 0, 2, 0x80, 0x41, 0x80}
+	assert.Equal(t, expect, response.GetData())
+}
+
+func TestDiagnosticsNetworkTransport(t *testing.T) {
+	mr := NewMemRegister()
+	s := NewServer(WithRegister(mr))
+
+	// Diagnostic function codes are not supported without a serial link.
+	for _, frame := range []*TCPFrame{newTestDiagnosticFrame(0x04, 0), newTestTCPFrame(11), newTestTCPFrame(12)} {
+		require.Equal(t, IllegalFunction, GetException(s.handle(&Request{frame: frame})))
+	}
+
+	// Forcing listen only mode from the network does not silence the server.
+	write := newTestTCPFrame(6)
+	SetDataWithRegisterAndNumber(write, 1, 1)
+	assertSuccess(t, s.handle(&Request{frame: write}))
+	assert.Equal(t, uint16(1), mr.HoldingRegisters[1])
+
+	assert.False(t, s.Diagnostics().ListenOnly())
+	assert.Equal(t, DiagnosticCounters{}, s.Diagnostics().Counters())
+}
+
+func TestCommunicationEventLogLength(t *testing.T) {
+	d := newDiagnostics(nil)
+
+	for range 100 {
+		d.communicationError()
+	}
+
+	assert.Len(t, d.Events(), 64)
+	assert.Equal(t, uint16(100), d.Counters().BusCommunicationErrors)
+}
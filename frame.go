@@ -15,12 +15,26 @@ type Framer interface {
 // GetException returns the Modbus exception or Success (indicating not exception).
 func GetException(frame Framer) (exception Exception) {
 	function := frame.GetFunction()
-	if (function & 0x80) != 0 {
-		exception = Exception(frame.GetData()[0])
+	if data := frame.GetData(); (function&0x80) != 0 && len(data) > 0 {
+		exception = Exception(data[0])
 	}
 	return exception
 }
 
+// unitAddress returns the unit identifier of frame and whether frame was
+// received on a serial line, where address 0 is the broadcast address.
+func unitAddress(frame Framer) (address uint8, serialLine bool) {
+	switch frame := frame.(type) {
+	case *RTUFrame:
+		return frame.Address, true
+	case *ASCIIFrame:
+		return frame.Address, true
+	case *TCPFrame:
+		return frame.Device, false
+	}
+	return 0, false
+}
+
 func registerAddressAndNumber(frame Framer) (register, numRegs int) {
 	data := frame.GetData()
 
@@ -0,0 +1,99 @@
+package mbserver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// errLRC is wrapped by the errors of ASCII frames whose LRC does not match.
+var errLRC = errors.New("LRC")
+
+// ASCIIFrame is the Modbus ASCII frame.
+type ASCIIFrame struct {
+	Data     []byte
+	LRC      uint8
+	Address  uint8
+	Function uint8
+}
+
+// NewASCIIFrame converts a packet, from the colon to the CR LF pair, to a Modbus ASCII frame.
+func NewASCIIFrame(packet []byte) (*ASCIIFrame, error) {
+	// Colon, address, function, LRC and CR LF.
+	if len(packet) < 9 {
+		return nil, fmt.Errorf("ASCII Frame error: packet less than 9 bytes: %q", packet)
+	}
+
+	if packet[0] != ':' || !bytes.HasSuffix(packet, []byte("\r\n")) {
+		return nil, fmt.Errorf("ASCII Frame error: missing delimiters: %q", packet)
+	}
+
+	raw := make([]byte, hex.DecodedLen(len(packet)-3))
+	if _, err := hex.Decode(raw, packet[1:len(packet)-2]); err != nil {
+		return nil, fmt.Errorf("ASCII Frame error: %w", err)
+	}
+
+	// Check the LRC.
+	pLen := len(raw)
+	lrcExpect := raw[pLen-1]
+	lrcCalc := lrc(raw[0 : pLen-1])
+	if lrcCalc != lrcExpect {
+		return nil, fmt.Errorf("ASCII Frame error: %w (expected 0x%x, got 0x%x)", errLRC, lrcExpect, lrcCalc)
+	}
+
+	frame := &ASCIIFrame{
+		Address:  raw[0],
+		Function: raw[1],
+		Data:     raw[2 : pLen-1],
+		LRC:      lrcExpect,
+	}
+
+	return frame, nil
+}
+
+// Copy the ASCIIFrame.
+func (frame *ASCIIFrame) Copy() Framer {
+	copy := *frame
+	return &copy
+}
+
+// Bytes returns the Modbus byte stream based on the ASCIIFrame fields
+func (frame *ASCIIFrame) Bytes() []byte {
+	raw := make([]byte, 2, 3+len(frame.Data))
+
+	raw[0] = frame.Address
+	raw[1] = frame.Function
+	raw = append(raw, frame.Data...)
+
+	// Add the LRC.
+	raw = append(raw, lrc(raw))
+
+	bytes := make([]byte, 1, 3+hex.EncodedLen(len(raw)))
+	bytes[0] = ':'
+	bytes = append(bytes, []byte(fmt.Sprintf("%X", raw))...)
+	bytes = append(bytes, '\r', '\n')
+
+	return bytes
+}
+
+// GetFunction returns the Modbus function code.
+func (frame *ASCIIFrame) GetFunction() uint8 {
+	return frame.Function
+}
+
+// GetData returns the ASCIIFrame Data byte field.
+func (frame *ASCIIFrame) GetData() []byte {
+	return frame.Data
+}
+
+// SetData sets the ASCIIFrame Data byte field.
+func (frame *ASCIIFrame) SetData(data []byte) {
+	frame.Data = data
+}
+
+// SetException sets the Modbus exception code in the frame.
+func (frame *ASCIIFrame) SetException(exception Exception) {
+	frame.Function = frame.Function | 0x80
+	frame.Data = []byte{byte(exception)}
+}
@@ -0,0 +1,66 @@
+package mbserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewASCIIFrame(t *testing.T) {
+	frame, err := NewASCIIFrame([]byte(":010300000001FB\r\n"))
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 1, frame.Address)
+	assert.EqualValues(t, 3, frame.Function)
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, 0x01}, frame.Data)
+	assert.EqualValues(t, 0xFB, frame.LRC)
+}
+
+func TestNewASCIIFrameLowerCaseHex(t *testing.T) {
+	frame, err := NewASCIIFrame([]byte(":01030000000af2\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, 0x0A}, frame.Data)
+}
+
+func TestNewASCIIFrameErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		packet    string
+		wantError string
+	}{
+		{"short packet", ":0103\r\n", "packet less than 9 bytes"},
+		{"missing colon", "0010300000001FB\r\n", "missing delimiters"},
+		{"missing CR LF", ":010300000001FB\n\n", "missing delimiters"},
+		{"invalid hex", ":0103000000G1FB\r\n", "invalid byte"},
+		{"odd hex length", ":010300000001F\r\n", "odd length"},
+		{"bad LRC", ":010300000001FC\r\n", "LRC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewASCIIFrame([]byte(tt.packet))
+			require.Error(t, err)
+			assert.ErrorContains(t, err, tt.wantError)
+		})
+	}
+}
+
+func TestASCIIFrameBytes(t *testing.T) {
+	frame := &ASCIIFrame{
+		Address:  1,
+		Function: 3,
+		Data:     []byte{0x02, 0x00, 0x0A},
+	}
+
+	assert.Equal(t, []byte(":010302000AF0\r\n"), frame.Bytes())
+}
+
+func TestASCIIFrameSetException(t *testing.T) {
+	frame := &ASCIIFrame{Function: 3, Data: []byte{0x01, 0x02}}
+
+	frame.SetException(IllegalDataAddress)
+
+	assert.EqualValues(t, 0x83, frame.Function)
+	assert.Equal(t, []byte{byte(IllegalDataAddress)}, frame.Data)
+}
@@ -2,9 +2,13 @@ package mbserver
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
+// errCRC is wrapped by the errors of RTU frames whose CRC does not match.
+var errCRC = errors.New("CRC")
+
 // RTUFrame is the Modbus TCP frame.
 type RTUFrame struct {
 	Data     []byte
@@ -16,8 +20,8 @@ type RTUFrame struct {
 // NewRTUFrame converts a packet to a Modbus TCP frame.
 func NewRTUFrame(packet []byte) (*RTUFrame, error) {
 	// Check the that the packet length.
-	if len(packet) < 5 {
-		return nil, fmt.Errorf("RTU Frame error: packet less than 5 bytes: %v", packet)
+	if len(packet) < 4 {
+		return nil, fmt.Errorf("RTU Frame error: packet less than 4 bytes: %v", packet)
 	}
 
 	// Check the CRC.
@@ -25,7 +29,7 @@ func NewRTUFrame(packet []byte) (*RTUFrame, error) {
 	crcExpect := binary.LittleEndian.Uint16(packet[pLen-2 : pLen])
 	crcCalc := crc16IBM(packet[0 : pLen-2])
 	if crcCalc != crcExpect {
-		return nil, fmt.Errorf("RTU Frame error: CRC (expected 0x%x, got 0x%x)", crcExpect, crcCalc)
+		return nil, fmt.Errorf("RTU Frame error: %w (expected 0x%x, got 0x%x)", errCRC, crcExpect, crcCalc)
 	}
 
 	frame := &RTUFrame{
@@ -37,6 +41,61 @@ func NewRTUFrame(packet []byte) (*RTUFrame, error) {
 	return frame, nil
 }
 
+// maxRTUFrameLength is the length of an RTU frame carrying a 253 byte PDU.
+const maxRTUFrameLength = 256
+
+// rtuRequestLength predicts the length of the RTU request frame at the start of
+// packet from its function code. It returns 0 when more bytes are needed to
+// tell, and -1 when the length cannot be predicted.
+func rtuRequestLength(packet []byte) int {
+	if len(packet) < 2 {
+		return 0
+	}
+
+	// Byte count fields, when present, give the length of the remaining data.
+	withByteCount := func(offset, overhead int) int {
+		if len(packet) <= offset {
+			return 0
+		}
+		return overhead + int(packet[offset])
+	}
+
+	switch packet[1] {
+	case 1, 2, 3, 4, 5, 6:
+		return 8
+	case 7, 11, 12, 17:
+		return 4
+	case 8:
+		// Return Query Data echoes data of any length.
+		if len(packet) < 4 {
+			return 0
+		}
+		if packet[2] == 0 && packet[3] == diagnosticReturnQueryData {
+			return -1
+		}
+		return 8
+	case 15, 16:
+		return withByteCount(6, 9)
+	case 20, 21:
+		return withByteCount(2, 5)
+	case 22:
+		return 10
+	case 23:
+		return withByteCount(10, 13)
+	case 24:
+		return 6
+	case 43:
+		if len(packet) < 3 {
+			return 0
+		}
+		if packet[2] == meiReadDeviceIdentification {
+			return 7
+		}
+	}
+
+	return -1
+}
+
 // Copy the RTUFrame.
 func (frame *RTUFrame) Copy() Framer {
 	copy := *frame
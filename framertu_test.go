@@ -50,3 +50,13 @@ func TestRTUFrameSetException(t *testing.T) {
 	assert.EqualValues(t, 0x84, frame.Function)
 	assert.Equal(t, []byte{byte(IllegalDataAddress)}, frame.Data)
 }
+
+func TestNewRTUFrameWithoutData(t *testing.T) {
+	// Report Server ID requests carry no data.
+	packet := (&RTUFrame{Address: 1, Function: 17}).Bytes()
+
+	frame, err := NewRTUFrame(packet)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 17, frame.Function)
+	assert.Empty(t, frame.Data)
+}
@@ -6,8 +6,92 @@ import (
 
 type Function func(Register, Framer) ([]byte, Exception)
 
+// Quantity limits of the Modbus application protocol specification, which keep
+// the responses within the 253 byte PDU.
+const (
+	maxReadBits       = 2000
+	maxReadRegisters  = 125
+	maxWriteBits      = 1968
+	maxWriteRegisters = 123
+)
+
+// coilOn and coilOff are the only values allowed in a Write Single Coil.
+const (
+	coilOn  = 0xFF00
+	coilOff = 0x0000
+)
+
+// validated returns function preceded by check, unless the server has been
+// made lenient with WithLenientValidation.
+func (s *Server) validated(check func(frame Framer) Exception, function Function) Function {
+	return func(r Register, frame Framer) ([]byte, Exception) {
+		if !s.lenient {
+			if exception := check(frame); exception != Success {
+				return []byte{}, exception
+			}
+		}
+		return function(r, frame)
+	}
+}
+
+// checkRead checks a read request of 1 to max coils, inputs or registers.
+func checkRead(max int) func(frame Framer) Exception {
+	return func(frame Framer) Exception {
+		data := frame.GetData()
+		if len(data) != 4 {
+			return IllegalDataValue
+		}
+		if quantity := int(binary.BigEndian.Uint16(data[2:4])); quantity < 1 || quantity > max {
+			return IllegalDataValue
+		}
+		return Success
+	}
+}
+
+// checkWriteSingleCoil checks that a Write Single Coil sets the coil on or off.
+func checkWriteSingleCoil(frame Framer) Exception {
+	data := frame.GetData()
+	if len(data) != 4 {
+		return IllegalDataValue
+	}
+	if value := binary.BigEndian.Uint16(data[2:4]); value != coilOn && value != coilOff {
+		return IllegalDataValue
+	}
+	return Success
+}
+
+func checkWriteSingleRegister(frame Framer) Exception {
+	if len(frame.GetData()) != 4 {
+		return IllegalDataValue
+	}
+	return Success
+}
+
+// checkWriteMultiple checks a write of 1 to max coils or registers of
+// bitSize bits, and that the byte count matches the quantity and the values.
+func checkWriteMultiple(max, bitSize int) func(frame Framer) Exception {
+	return func(frame Framer) Exception {
+		data := frame.GetData()
+		if len(data) < 5 {
+			return IllegalDataValue
+		}
+		quantity := int(binary.BigEndian.Uint16(data[2:4]))
+		if quantity < 1 || quantity > max {
+			return IllegalDataValue
+		}
+		byteCount := int(data[4])
+		if byteCount != (quantity*bitSize+7)/8 || len(data) != 5+byteCount {
+			return IllegalDataValue
+		}
+		return Success
+	}
+}
+
 // readCoils function 1, reads coils from internal memory.
 func readCoils(r Register, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) < 4 {
+		return []byte{}, IllegalDataValue
+	}
 	register, numRegs := registerAddressAndNumber(frame)
 	if register > 65535 || register+numRegs > 65536 {
 		return []byte{}, IllegalDataAddress
@@ -36,6 +120,9 @@ func readCoils(r Register, frame Framer) ([]byte, Exception) {
 
 // readDiscreteInputs function 2, reads discrete inputs from internal memory.
 func readDiscreteInputs(r Register, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) < 4 {
+		return []byte{}, IllegalDataValue
+	}
 	register, numRegs := registerAddressAndNumber(frame)
 	if register > 65535 || register+numRegs > 65536 {
 		return []byte{}, IllegalDataAddress
@@ -65,6 +152,9 @@ func readDiscreteInputs(r Register, frame Framer) ([]byte, Exception) {
 
 // readHoldingRegisters function 3, reads holding registers from internal memory.
 func readHoldingRegisters(r Register, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) < 4 {
+		return []byte{}, IllegalDataValue
+	}
 	register, numRegs := registerAddressAndNumber(frame)
 	if register > 65535 || register+numRegs > 65536 {
 		return []byte{}, IllegalDataAddress
@@ -84,6 +174,9 @@ func readHoldingRegisters(r Register, frame Framer) ([]byte, Exception) {
 
 // readInputRegisters function 4, reads input registers from internal memory.
 func readInputRegisters(r Register, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) < 4 {
+		return []byte{}, IllegalDataValue
+	}
 	register, numRegs := registerAddressAndNumber(frame)
 	if register > 65535 || register+numRegs > 65536 {
 		return []byte{}, IllegalDataAddress
@@ -103,6 +196,9 @@ func readInputRegisters(r Register, frame Framer) ([]byte, Exception) {
 
 // writeSingleCoil function 5, write a coil to internal memory.
 func writeSingleCoil(r Register, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) < 4 {
+		return []byte{}, IllegalDataValue
+	}
 	register, value := registerAddressAndValue(frame)
 	if value != 0 {
 		value = 1 // Modbus standard uses 0 for off and 1 for on
@@ -117,6 +213,9 @@ func writeSingleCoil(r Register, frame Framer) ([]byte, Exception) {
 
 // writeSingleRegister function 6, write a holding register to internal memory.
 func writeSingleRegister(r Register, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) < 4 {
+		return []byte{}, IllegalDataValue
+	}
 	register, value := registerAddressAndValue(frame)
 
 	if exception := r.WriteSingleRegister(register, value); exception != Success {
@@ -126,8 +225,25 @@ func writeSingleRegister(r Register, frame Framer) ([]byte, Exception) {
 	return frame.GetData()[0:4], Success
 }
 
+// readExceptionStatus function 7, reads the eight exception status outputs.
+func (s *Server) readExceptionStatus(r Register, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) != 0 {
+		return []byte{}, IllegalDataValue
+	}
+
+	var status uint8
+	if s.exceptionStatus != nil {
+		status = s.exceptionStatus(r)
+	}
+
+	return []byte{status}, Success
+}
+
 // writeMultipleCoils function 15, writes holding registers to internal memory.
 func writeMultipleCoils(r Register, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) < 5 {
+		return []byte{}, IllegalDataValue
+	}
 	register, numRegs := registerAddressAndNumber(frame)
 	valueBytes := frame.GetData()[5:]
 
@@ -143,7 +259,7 @@ func writeMultipleCoils(r Register, frame Framer) ([]byte, Exception) {
 	bitCount := 0
 	bitValue := make([]bool, numRegs)
 
-	for i, value := range valueBytes {
+	for i, value := range valueBytes[:expectedBytes] {
 		for bitPos := uint(0); bitPos < 8; bitPos++ {
 			bitValue[(i*8)+int(bitPos)] = bitAtPosition(value, bitPos) != 0
 			bitCount++
@@ -165,6 +281,9 @@ func writeMultipleCoils(r Register, frame Framer) ([]byte, Exception) {
 
 // writeMultipleRegisters function 16, writes holding registers to internal memory.
 func writeMultipleRegisters(r Register, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) < 5 {
+		return []byte{}, IllegalDataValue
+	}
 	register, numRegs := registerAddressAndNumber(frame)
 	valueBytes := frame.GetData()[5:]
 
@@ -184,6 +303,249 @@ func writeMultipleRegisters(r Register, frame Framer) ([]byte, Exception) {
 	return frame.GetData()[0:4], Success
 }
 
+// maskWriteRegister function 22, modifies a holding register in internal memory
+// with an AND mask and an OR mask.
+func maskWriteRegister(r Register, frame Framer) ([]byte, Exception) {
+	data := frame.GetData()
+	if len(data) != 6 {
+		return []byte{}, IllegalDataValue
+	}
+
+	register := int(binary.BigEndian.Uint16(data[0:2]))
+	andMask := binary.BigEndian.Uint16(data[2:4])
+	orMask := binary.BigEndian.Uint16(data[4:6])
+
+	if mw, ok := r.(MaskWriter); ok {
+		if exception := mw.MaskWriteRegister(register, andMask, orMask); exception != Success {
+			return []byte{}, exception
+		}
+		return data, Success
+	}
+
+	current, exception := r.ReadHoldingRegisters(register, 1)
+	if exception != Success {
+		return []byte{}, exception
+	}
+
+	if exception := r.WriteSingleRegister(register, maskRegister(current[0], andMask, orMask)); exception != Success {
+		return []byte{}, exception
+	}
+
+	return data, Success
+}
+
+// readWriteMultipleRegisters function 23, writes holding registers to internal memory
+// and then reads holding registers back from internal memory.
+func readWriteMultipleRegisters(r Register, frame Framer) ([]byte, Exception) {
+	data := frame.GetData()
+	if len(data) < 9 {
+		return []byte{}, IllegalDataValue
+	}
+
+	readRegister, readNumRegs := registerAddressAndNumber(frame)
+	writeRegister := int(binary.BigEndian.Uint16(data[4:6]))
+	writeNumRegs := int(binary.BigEndian.Uint16(data[6:8]))
+	byteCount := int(data[8])
+	valueBytes := data[9:]
+
+	if readNumRegs < 1 || readNumRegs > 125 || writeNumRegs < 1 || writeNumRegs > 121 {
+		return []byte{}, IllegalDataValue
+	}
+
+	if byteCount != writeNumRegs*2 || len(valueBytes) != byteCount {
+		return []byte{}, IllegalDataValue
+	}
+
+	if readRegister+readNumRegs > 65536 || writeRegister+writeNumRegs > 65536 {
+		return []byte{}, IllegalDataAddress
+	}
+
+	// The write operation is performed before the read, as required by the specification.
+	if exception := r.WriteMultipleRegisters(writeRegister, BytesToUint16(valueBytes)); exception != Success {
+		return []byte{}, exception
+	}
+
+	hRegisters, exception := r.ReadHoldingRegisters(readRegister, readNumRegs)
+	if exception != Success {
+		return []byte{}, exception
+	}
+
+	response := make([]byte, 1, 1+readNumRegs*2)
+	response[0] = byte(readNumRegs * 2)
+	response = append(response, Uint16ToBytes(hRegisters)...)
+
+	return response, Success
+}
+
+// readFIFOQueue function 24, reads the FIFO queue of a register implementing FIFORegister.
+func readFIFOQueue(r Register, frame Framer) ([]byte, Exception) {
+	fifo, ok := r.(FIFORegister)
+	if !ok {
+		return []byte{}, IllegalFunction
+	}
+
+	data := frame.GetData()
+	if len(data) != 2 {
+		return []byte{}, IllegalDataValue
+	}
+
+	values, exception := fifo.ReadFIFOQueue(int(binary.BigEndian.Uint16(data[0:2])))
+	if exception != Success {
+		return []byte{}, exception
+	}
+
+	if len(values) > 31 {
+		return []byte{}, IllegalDataValue
+	}
+
+	response := make([]byte, 4, 4+len(values)*2)
+	binary.BigEndian.PutUint16(response[0:2], uint16(2+len(values)*2))
+	binary.BigEndian.PutUint16(response[2:4], uint16(len(values)))
+	response = append(response, Uint16ToBytes(values)...)
+
+	return response, Success
+}
+
+// reportServerID function 17, reports the server ID and the run indicator status.
+func (s *Server) reportServerID(_ Register, frame Framer) ([]byte, Exception) {
+	if len(frame.GetData()) != 0 {
+		return []byte{}, IllegalDataValue
+	}
+
+	response := make([]byte, 1, 2+len(s.serverID))
+	response[0] = byte(len(s.serverID) + 1)
+	response = append(response, s.serverID...)
+	response = append(response, s.runIndicator)
+
+	return response, Success
+}
+
+// fileReferenceType is the only reference type allowed in file record sub-requests.
+const fileReferenceType = 0x06
+
+// fileSubRequest is a sub-request of Read File Record or Write File Record.
+type fileSubRequest struct {
+	file, record, length int
+	values               []byte
+}
+
+// parseFileSubRequests splits the sub-requests of a file record request. When
+// withValues is true each sub-request is followed by its record data.
+func parseFileSubRequests(data []byte, withValues bool) ([]fileSubRequest, Exception) {
+	var subRequests []fileSubRequest
+
+	for len(data) > 0 {
+		if len(data) < 7 {
+			return nil, IllegalDataValue
+		}
+
+		subRequest := fileSubRequest{
+			file:   int(binary.BigEndian.Uint16(data[1:3])),
+			record: int(binary.BigEndian.Uint16(data[3:5])),
+			length: int(binary.BigEndian.Uint16(data[5:7])),
+		}
+		if subRequest.length == 0 {
+			return nil, IllegalDataValue
+		}
+		if data[0] != fileReferenceType || subRequest.file == 0 ||
+			subRequest.record+subRequest.length > maxFileRecords {
+			return nil, IllegalDataAddress
+		}
+		data = data[7:]
+
+		if withValues {
+			if len(data) < subRequest.length*2 {
+				return nil, IllegalDataValue
+			}
+			subRequest.values = data[:subRequest.length*2]
+			data = data[subRequest.length*2:]
+		}
+
+		subRequests = append(subRequests, subRequest)
+	}
+
+	return subRequests, Success
+}
+
+// readFileRecord function 20, reads file records from the file store.
+func (s *Server) readFileRecord(_ Register, frame Framer) ([]byte, Exception) {
+	if s.fileStore == nil {
+		return []byte{}, IllegalFunction
+	}
+
+	data := frame.GetData()
+	if len(data) < 1 {
+		return []byte{}, IllegalDataValue
+	}
+
+	byteCount := int(data[0])
+	if byteCount < 0x07 || byteCount > 0xF5 || byteCount%7 != 0 || len(data)-1 != byteCount {
+		return []byte{}, IllegalDataValue
+	}
+
+	subRequests, exception := parseFileSubRequests(data[1:], false)
+	if exception != Success {
+		return []byte{}, exception
+	}
+
+	responseLength := 0
+	for _, subRequest := range subRequests {
+		responseLength += 2 + subRequest.length*2
+	}
+	if responseLength > 0xF5 {
+		return []byte{}, IllegalDataValue
+	}
+
+	response := make([]byte, 1, 1+responseLength)
+	response[0] = byte(responseLength)
+
+	for _, subRequest := range subRequests {
+		values, exception := s.fileStore.ReadFileRecord(subRequest.file, subRequest.record, subRequest.length)
+		if exception != Success {
+			return []byte{}, exception
+		}
+		// A store returning another length would corrupt the response.
+		if len(values) != subRequest.length {
+			return []byte{}, SlaveDeviceFailure
+		}
+		response = append(response, byte(1+len(values)*2), fileReferenceType)
+		response = append(response, Uint16ToBytes(values)...)
+	}
+
+	return response, Success
+}
+
+// writeFileRecord function 21, writes file records to the file store.
+func (s *Server) writeFileRecord(_ Register, frame Framer) ([]byte, Exception) {
+	if s.fileStore == nil {
+		return []byte{}, IllegalFunction
+	}
+
+	data := frame.GetData()
+	if len(data) < 1 {
+		return []byte{}, IllegalDataValue
+	}
+
+	byteCount := int(data[0])
+	if byteCount < 0x09 || byteCount > 0xFB || len(data)-1 != byteCount {
+		return []byte{}, IllegalDataValue
+	}
+
+	subRequests, exception := parseFileSubRequests(data[1:], true)
+	if exception != Success {
+		return []byte{}, exception
+	}
+
+	for _, subRequest := range subRequests {
+		exception := s.fileStore.WriteFileRecord(subRequest.file, subRequest.record, BytesToUint16(subRequest.values))
+		if exception != Success {
+			return []byte{}, exception
+		}
+	}
+
+	return data, Success
+}
+
 // BytesToUint16 converts a big endian array of bytes to an array of unit16s
 func BytesToUint16(bytes []byte) []uint16 {
 	values := make([]uint16, len(bytes)/2)
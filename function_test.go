@@ -102,11 +102,22 @@ func TestWriteSingleCoil(t *testing.T) {
 	s := NewServer(WithRegister(mr))
 
 	frame := newTestTCPFrame(5)
-	SetDataWithRegisterAndNumber(frame, 65535, 1024)
+	SetDataWithRegisterAndNumber(frame, 65535, 0xFF00)
 
 	response := s.handle(&Request{frame: frame})
 	assertSuccess(t, response)
 	assert.Equal(t, true, mr.Coils[65535])
+
+	SetDataWithRegisterAndNumber(frame, 65535, 1024)
+	response = s.handle(&Request{frame: frame})
+	assert.Equal(t, IllegalDataValue, GetException(response))
+
+	// A lenient server takes any non zero value as on.
+	mr = NewMemRegister()
+	s = NewServer(WithRegister(mr), WithLenientValidation())
+	response = s.handle(&Request{frame: frame})
+	assertSuccess(t, response)
+	assert.Equal(t, true, mr.Coils[65535])
 }
 
 // Function 6
@@ -122,6 +133,38 @@ func TestWriteHoldingRegister(t *testing.T) {
 	assert.Equal(t, uint16(6), mr.HoldingRegisters[5])
 }
 
+// Function 7
+func TestReadExceptionStatus(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		s := NewServer()
+
+		response := s.handle(&Request{frame: newTestTCPFrame(7)})
+		assertSuccess(t, response)
+		assert.Equal(t, []byte{0x00}, response.GetData())
+	})
+
+	t.Run("callback", func(t *testing.T) {
+		s := NewServer(WithExceptionStatus(func(Register) uint8 { return 0x6D }))
+
+		response := s.handle(&Request{frame: newTestTCPFrame(7)})
+		assertSuccess(t, response)
+		assert.Equal(t, []byte{0x6D}, response.GetData())
+	})
+
+	t.Run("coil mapping", func(t *testing.T) {
+		mr := NewMemRegister()
+		s := NewServer(WithRegister(mr), WithExceptionStatus(ExceptionStatusCoils(100)))
+		mr.Coils[100] = true
+		mr.Coils[102] = true
+		mr.Coils[107] = true
+		mr.Coils[108] = true
+
+		response := s.handle(&Request{frame: newTestTCPFrame(7)})
+		assertSuccess(t, response)
+		assert.Equal(t, []byte{0x85}, response.GetData())
+	})
+}
+
 // Function 15
 func TestWriteMultipleCoils(t *testing.T) {
 	mr := NewMemRegister()
@@ -148,6 +191,246 @@ func TestWriteHoldingRegisters(t *testing.T) {
 	assert.Equal(t, []uint16{3, 4}, mr.HoldingRegisters[1:3])
 }
 
+// Function 17
+func TestReportServerID(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		s := NewServer()
+
+		response := s.handle(&Request{frame: newTestTCPFrame(17)})
+		assertSuccess(t, response)
+		assert.Equal(t, []byte{0x01, 0xFF}, response.GetData())
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		s := NewServer(WithServerID([]byte("mb-1.2"), false))
+
+		response := s.handle(&Request{frame: newTestTCPFrame(17)})
+		assertSuccess(t, response)
+		assert.Equal(t, []byte{0x07, 'm', 'b', '-', '1', '.', '2', 0x00}, response.GetData())
+	})
+
+	t.Run("truncated to the maximum frame size", func(t *testing.T) {
+		s := NewServer(WithServerID(make([]byte, 300), true))
+
+		response := s.handle(&Request{frame: newTestRTUFrame(1, 17)})
+		assertSuccess(t, response)
+		assert.Equal(t, byte(251), response.GetData()[0])
+		assert.Len(t, response.Bytes(), 256)
+	})
+
+	t.Run("unexpected data", func(t *testing.T) {
+		s := NewServer()
+
+		frame := newTestTCPFrame(17)
+		frame.SetData([]byte{0x00})
+
+		response := s.handle(&Request{frame: frame})
+		require.Equal(t, IllegalDataValue, GetException(response))
+	})
+}
+
+// Function 20
+func TestReadFileRecord(t *testing.T) {
+	fs := NewMemFileStore()
+	s := NewServer(WithFileStore(fs))
+	fs.Files[4] = []uint16{0, 0x0DFE, 0x0020}
+	fs.Files[3] = []uint16{0, 0, 0, 0, 0, 0, 0, 0, 0, 0x33CD, 0x0040}
+
+	frame := newTestTCPFrame(20)
+	frame.SetData([]byte{0x0E,
+		0x06, 0x00, 0x04, 0x00, 0x01, 0x00, 0x02,
+		0x06, 0x00, 0x03, 0x00, 0x09, 0x00, 0x02})
+
+	response := s.handle(&Request{frame: frame})
+	assertSuccess(t, response)
+
+	expected := []byte{0x0C,
+		0x05, 0x06, 0x0D, 0xFE, 0x00, 0x20,
+		0x05, 0x06, 0x33, 0xCD, 0x00, 0x40}
+	assert.Equal(t, expected, response.GetData())
+}
+
+// Function 21
+func TestWriteFileRecord(t *testing.T) {
+	fs := NewMemFileStore()
+	s := NewServer(WithFileStore(fs))
+
+	data := []byte{0x0D, 0x06, 0x00, 0x04, 0x00, 0x07, 0x00, 0x03, 0x06, 0xAF, 0x04, 0xBE, 0x10, 0x0D}
+	frame := newTestTCPFrame(21)
+	frame.SetData(data)
+
+	response := s.handle(&Request{frame: frame})
+	assertSuccess(t, response)
+	assert.Equal(t, data, response.GetData())
+	assert.Equal(t, []uint16{0x06AF, 0x04BE, 0x100D}, fs.Files[4][7:10])
+}
+
+func TestFileRecordExceptions(t *testing.T) {
+	fs := NewMemFileStore()
+	fs.Files[1] = make([]uint16, 10)
+	s := NewServer(WithFileStore(fs))
+
+	tests := []struct {
+		name     string
+		function uint8
+		data     []byte
+		expect   Exception
+	}{
+		{"read byte count too small", 20, []byte{0x00}, IllegalDataValue},
+		{"read byte count mismatch", 20, []byte{0x0E, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}, IllegalDataValue},
+		{"read invalid reference type", 20, []byte{0x07, 0x07, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}, IllegalDataAddress},
+		{"read file zero", 20, []byte{0x07, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, IllegalDataAddress},
+		{"read record beyond limit", 20, []byte{0x07, 0x06, 0x00, 0x01, 0x27, 0x10, 0x00, 0x01}, IllegalDataAddress},
+		{"read record beyond file", 20, []byte{0x07, 0x06, 0x00, 0x01, 0x00, 0x09, 0x00, 0x02}, IllegalDataAddress},
+		{"read response too long", 20, []byte{0x07, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x7B}, IllegalDataValue},
+		{"write byte count mismatch", 21, []byte{0x0A, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01}, IllegalDataValue},
+		{"write missing record data", 21, []byte{0x09, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01}, IllegalDataValue},
+		{"write invalid reference type", 21, []byte{0x09, 0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01}, IllegalDataAddress},
+		{"write record beyond limit", 21, []byte{0x0B, 0x06, 0x00, 0x01, 0x27, 0x0F, 0x00, 0x02, 0x00, 0x01, 0x00, 0x02}, IllegalDataAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := newTestTCPFrame(tt.function)
+			frame.SetData(tt.data)
+
+			response := s.handle(&Request{frame: frame})
+			require.Equal(t, tt.expect, GetException(response))
+		})
+	}
+
+	t.Run("no file store", func(t *testing.T) {
+		s := NewServer()
+
+		frame := newTestTCPFrame(20)
+		frame.SetData([]byte{0x07, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01})
+
+		response := s.handle(&Request{frame: frame})
+		require.Equal(t, IllegalFunction, GetException(response))
+	})
+
+	t.Run("store returns wrong length", func(t *testing.T) {
+		s := NewServer(WithFileStore(shortFileStore{}))
+
+		frame := newTestTCPFrame(20)
+		frame.SetData([]byte{0x07, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02})
+
+		response := s.handle(&Request{frame: frame})
+		require.Equal(t, SlaveDeviceFailure, GetException(response))
+	})
+}
+
+// shortFileStore returns one record less than requested.
+type shortFileStore struct{}
+
+func (shortFileStore) ReadFileRecord(file, record, length int) ([]uint16, Exception) {
+	return make([]uint16, length-1), Success
+}
+
+func (shortFileStore) WriteFileRecord(file, record int, values []uint16) Exception {
+	return Success
+}
+
+// Function 22
+func TestMaskWriteRegister(t *testing.T) {
+	mr := NewMemRegister()
+	s := NewServer(WithRegister(mr))
+	mr.HoldingRegisters[4] = 0x12
+
+	frame := newTestTCPFrame(22)
+	frame.SetData([]byte{0, 4, 0, 0xF2, 0, 0x25})
+
+	response := s.handle(&Request{frame: frame})
+	assertSuccess(t, response)
+	assert.Equal(t, uint16(0x17), mr.HoldingRegisters[4])
+	assert.Equal(t, []byte{0, 4, 0, 0xF2, 0, 0x25}, response.GetData())
+}
+
+// plainRegister hides the optional interfaces implemented by MemRegister.
+type plainRegister struct {
+	Register
+}
+
+func TestMaskWriteRegisterWithoutMaskWriter(t *testing.T) {
+	mr := NewMemRegister()
+	s := NewServer(WithRegister(plainRegister{mr}))
+	mr.HoldingRegisters[4] = 0x12
+
+	frame := newTestTCPFrame(22)
+	frame.SetData([]byte{0, 4, 0, 0xF2, 0, 0x25})
+
+	response := s.handle(&Request{frame: frame})
+	assertSuccess(t, response)
+	assert.Equal(t, uint16(0x17), mr.HoldingRegisters[4])
+}
+
+// Function 23
+func TestReadWriteMultipleRegisters(t *testing.T) {
+	mr := NewMemRegister()
+	s := NewServer(WithRegister(mr))
+	mr.HoldingRegisters[10] = 7
+
+	frame := newTestTCPFrame(23)
+	// Write 2 registers at 11 and read 3 registers starting at 10.
+	frame.SetData([]byte{0, 10, 0, 3, 0, 11, 0, 2, 4, 0, 8, 0, 9})
+
+	response := s.handle(&Request{frame: frame})
+	assertSuccess(t, response)
+	assert.Equal(t, []uint16{8, 9}, mr.HoldingRegisters[11:13])
+
+	expected := []byte{6, 0, 7, 0, 8, 0, 9}
+	assert.Equal(t, expected, response.GetData())
+}
+
+// Function 24
+func TestReadFIFOQueue(t *testing.T) {
+	mr := NewMemRegister()
+	s := NewServer(WithRegister(mr))
+	mr.DeclareFIFO(0x04DE)
+	require.Equal(t, Success, mr.PushFIFO(0x04DE, 0x01B8, 0x1284))
+
+	frame := newTestTCPFrame(24)
+	frame.SetData([]byte{0x04, 0xDE})
+
+	response := s.handle(&Request{frame: frame})
+	assertSuccess(t, response)
+
+	expected := []byte{0x00, 0x06, 0x00, 0x02, 0x01, 0xB8, 0x12, 0x84}
+	assert.Equal(t, expected, response.GetData())
+}
+
+func TestReadFIFOQueueExceptions(t *testing.T) {
+	mr := NewMemRegister()
+	mr.DeclareFIFO(1)
+	for i := range 32 {
+		mr.PushFIFO(1, uint16(i))
+	}
+
+	tests := []struct {
+		name     string
+		register Register
+		data     []byte
+		expect   Exception
+	}{
+		{"queue too long", mr, []byte{0x00, 0x01}, IllegalDataValue},
+		{"undeclared queue", mr, []byte{0x00, 0x02}, IllegalDataAddress},
+		{"short request", mr, []byte{0x00}, IllegalDataValue},
+		{"register without FIFO support", plainRegister{mr}, []byte{0x00, 0x01}, IllegalFunction},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(WithRegister(tt.register))
+
+			frame := newTestTCPFrame(24)
+			frame.SetData(tt.data)
+
+			response := s.handle(&Request{frame: frame})
+			require.Equal(t, tt.expect, GetException(response))
+		})
+	}
+}
+
 func TestBytesToUint16(t *testing.T) {
 	bytes := []byte{1, 2, 3, 4}
 	got := BytesToUint16(bytes)
@@ -220,6 +503,22 @@ func TestOutOfBounds(t *testing.T) {
 			},
 			expect: IllegalDataAddress,
 		},
+		{
+			name:     "read write holding registers read overflow",
+			function: 23,
+			setData: func(frame *TCPFrame) {
+				frame.SetData([]byte{255, 255, 0, 2, 0, 0, 0, 1, 2, 0, 0})
+			},
+			expect: IllegalDataAddress,
+		},
+		{
+			name:     "read write holding registers write overflow",
+			function: 23,
+			setData: func(frame *TCPFrame) {
+				frame.SetData([]byte{0, 0, 0, 1, 255, 255, 0, 2, 4, 0, 0, 0, 0})
+			},
+			expect: IllegalDataAddress,
+		},
 	}
 
 	for _, tt := range tests {
@@ -256,6 +555,139 @@ func TestIllegalDataValue(t *testing.T) {
 				SetDataWithRegisterAndNumberAndBytes(frame, 1, 2, []byte{0, 1})
 			},
 		},
+		{
+			name:     "read coils zero quantity",
+			function: 1,
+			setData: func(frame *TCPFrame) {
+				SetDataWithRegisterAndNumber(frame, 0, 0)
+			},
+		},
+		{
+			name:     "read coils quantity too large",
+			function: 1,
+			setData: func(frame *TCPFrame) {
+				SetDataWithRegisterAndNumber(frame, 0, 2001)
+			},
+		},
+		{
+			name:     "read discrete inputs zero quantity",
+			function: 2,
+			setData: func(frame *TCPFrame) {
+				SetDataWithRegisterAndNumber(frame, 0, 0)
+			},
+		},
+		{
+			name:     "read discrete inputs quantity too large",
+			function: 2,
+			setData: func(frame *TCPFrame) {
+				SetDataWithRegisterAndNumber(frame, 0, 2001)
+			},
+		},
+		{
+			name:     "read holding registers zero quantity",
+			function: 3,
+			setData: func(frame *TCPFrame) {
+				SetDataWithRegisterAndNumber(frame, 0, 0)
+			},
+		},
+		{
+			name:     "read holding registers quantity too large",
+			function: 3,
+			setData: func(frame *TCPFrame) {
+				SetDataWithRegisterAndNumber(frame, 0, 126)
+			},
+		},
+		{
+			name:     "read input registers zero quantity",
+			function: 4,
+			setData: func(frame *TCPFrame) {
+				SetDataWithRegisterAndNumber(frame, 0, 0)
+			},
+		},
+		{
+			name:     "read input registers quantity too large",
+			function: 4,
+			setData: func(frame *TCPFrame) {
+				SetDataWithRegisterAndNumber(frame, 0, 126)
+			},
+		},
+		{
+			name:     "write single coil invalid value",
+			function: 5,
+			setData: func(frame *TCPFrame) {
+				SetDataWithRegisterAndNumber(frame, 0, 0x00FF)
+			},
+		},
+		{
+			name:     "write single register short request",
+			function: 6,
+			setData: func(frame *TCPFrame) {
+				frame.SetData([]byte{0, 1, 0})
+			},
+		},
+		{
+			name:     "write multiple coils quantity too large",
+			function: 15,
+			setData: func(frame *TCPFrame) {
+				SetDataWithRegisterAndNumberAndBytes(frame, 0, 1969, make([]byte, 247))
+			},
+		},
+		{
+			name:     "write multiple coils byte count mismatch",
+			function: 15,
+			setData: func(frame *TCPFrame) {
+				SetDataWithRegisterAndNumberAndBytes(frame, 0, 8, []byte{1, 0})
+			},
+		},
+		{
+			name:     "write holding registers quantity too large",
+			function: 16,
+			setData: func(frame *TCPFrame) {
+				SetDataWithRegisterAndNumberAndValues(frame, 0, 124, make([]uint16, 124))
+			},
+		},
+		{
+			name:     "write holding registers byte count field mismatch",
+			function: 16,
+			setData: func(frame *TCPFrame) {
+				frame.SetData([]byte{0, 0, 0, 1, 4, 0, 1})
+			},
+		},
+		{
+			name:     "mask write register short request",
+			function: 22,
+			setData: func(frame *TCPFrame) {
+				frame.SetData([]byte{0, 4, 0, 0xF2})
+			},
+		},
+		{
+			name:     "read write holding registers short request",
+			function: 23,
+			setData: func(frame *TCPFrame) {
+				frame.SetData([]byte{0, 0, 0, 1, 0, 0, 0, 1})
+			},
+		},
+		{
+			name:     "read write holding registers read quantity too large",
+			function: 23,
+			setData: func(frame *TCPFrame) {
+				frame.SetData([]byte{0, 0, 0, 126, 0, 0, 0, 1, 2, 0, 0})
+			},
+		},
+		{
+			name:     "read write holding registers write quantity too large",
+			function: 23,
+			setData: func(frame *TCPFrame) {
+				frame.SetData(append([]byte{0, 0, 0, 1, 0, 0, 0, 122, 244}, make([]byte, 244)...))
+			},
+		},
+		{
+			name:     "read write holding registers byte count mismatch",
+			function: 23,
+			setData: func(frame *TCPFrame) {
+				frame.SetData([]byte{0, 0, 0, 1, 0, 0, 0, 2, 2, 0, 0})
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -268,3 +700,45 @@ func TestIllegalDataValue(t *testing.T) {
 		})
 	}
 }
+
+func TestLenientValidation(t *testing.T) {
+	mr := NewMemRegister()
+	s := NewServer(WithRegister(mr), WithLenientValidation())
+
+	// Read quantities above the limits are accepted as before.
+	frame := newTestTCPFrame(3)
+	SetDataWithRegisterAndNumber(frame, 0, 126)
+	response := s.handle(&Request{frame: frame})
+	assertSuccess(t, response)
+	assert.Len(t, response.GetData(), 1+126*2)
+
+	frame = newTestTCPFrame(16)
+	SetDataWithRegisterAndNumberAndValues(frame, 0, 124, make([]uint16, 124))
+	assertSuccess(t, s.handle(&Request{frame: frame}))
+}
+
+func TestShortRequests(t *testing.T) {
+	s := NewServer(WithLenientValidation())
+
+	for _, function := range []uint8{1, 2, 3, 4, 5, 6, 15, 16} {
+		frame := newTestTCPFrame(function)
+		frame.SetData([]byte{0, 1})
+
+		response := s.handle(&Request{frame: frame})
+		assert.Equal(t, IllegalDataValue, GetException(response), "function %d", function)
+	}
+}
+
+func TestFunctionPanic(t *testing.T) {
+	s := NewServer(WithRegisterFunction(0x41, func(Register, Framer) ([]byte, Exception) {
+		panic("broken function")
+	}))
+
+	response := s.handle(&Request{frame: newTestTCPFrame(0x41)})
+	assert.Equal(t, SlaveDeviceFailure, GetException(response))
+
+	// The server keeps answering.
+	frame := newTestTCPFrame(3)
+	SetDataWithRegisterAndNumber(frame, 0, 1)
+	assertSuccess(t, s.handle(&Request{frame: frame}))
+}
@@ -0,0 +1,91 @@
+package mbserver
+
+import "context"
+
+// FunctionHandler handles a function code like Function, with access to the
+// origin of the request and to the server state, and control over the
+// response through w.
+type FunctionHandler func(w ResponseWriter, r *FunctionRequest)
+
+// FunctionRequest is the request passed to a FunctionHandler.
+type FunctionRequest struct {
+	RequestInfo
+
+	Frame Framer
+	// Register is the register of the unit the request is addressed to.
+	Register  Register
+	FileStore FileStore
+	// Diagnostics is the diagnostics of the serial link the request arrived
+	// on, nil on network transports.
+	Diagnostics *Diagnostics
+
+	ctx context.Context
+}
+
+// Context returns the context of the request, which carries its RequestInfo
+// and the values added by middlewares.
+func (r *FunctionRequest) Context() context.Context {
+	return r.ctx
+}
+
+// ResponseWriter builds the response of a FunctionHandler. A handler that
+// calls none of its methods answers with no data.
+type ResponseWriter interface {
+	// WriteData sets the data following the function code in the response.
+	WriteData(data []byte)
+	// WriteException answers with an exception response.
+	WriteException(exception Exception)
+	// NoResponse suppresses the response, as for a broadcast or in listen only mode.
+	NoResponse()
+}
+
+// response is the ResponseWriter given to function handlers.
+type response struct {
+	data       []byte
+	exception  Exception
+	noResponse bool
+}
+
+func (r *response) WriteData(data []byte) {
+	r.data = data
+	r.exception = Success
+}
+
+func (r *response) WriteException(exception Exception) {
+	r.exception = exception
+}
+
+func (r *response) NoResponse() {
+	r.noResponse = true
+}
+
+// AdaptFunction returns a FunctionHandler calling function with the register
+// of the unit the request is addressed to. It returns nil for a nil function.
+func AdaptFunction(function Function) FunctionHandler {
+	if function == nil {
+		return nil
+	}
+	return func(w ResponseWriter, r *FunctionRequest) {
+		data, exception := function(r.Register, r.Frame)
+		if exception != Success {
+			w.WriteException(exception)
+			return
+		}
+		w.WriteData(data)
+	}
+}
+
+// WithFunctionHandler registers a FunctionHandler for a specific function code,
+// replacing the built-in function or a function set by WithRegisterFunction.
+func WithFunctionHandler(funcCode uint8, handler FunctionHandler) OptionFunc {
+	return func(s *Server) {
+		s.function[funcCode] = handler
+	}
+}
+
+// WithUnitFunctionHandler registers a FunctionHandler used only by the unit.
+func WithUnitFunctionHandler(funcCode uint8, handler FunctionHandler) UnitOptionFunc {
+	return func(u *unit) {
+		u.function[funcCode] = handler
+	}
+}
@@ -0,0 +1,112 @@
+package mbserver
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFunctionHandler(t *testing.T) {
+	t.Run("writes data", func(t *testing.T) {
+		store := NewMemFileStore()
+		s := NewServer(WithFileStore(store), WithFunctionHandler(0x41, func(w ResponseWriter, r *FunctionRequest) {
+			assert.Same(t, store, r.FileStore)
+			assert.NotNil(t, r.Register)
+			assert.NotNil(t, r.Diagnostics)
+			assert.NotNil(t, r.Context())
+			w.WriteData(append([]byte{r.UnitID}, r.Frame.GetData()...))
+		}))
+
+		frame := newTestTCPFrame(0x41)
+		frame.Device = 9
+		frame.Data = []byte{1, 2}
+		response := s.handle(&Request{frame: frame, diagnostics: newDiagnostics(s.diagnostics)})
+
+		require.NotNil(t, response)
+		assert.Equal(t, []byte{9, 1, 2}, response.GetData())
+	})
+
+	t.Run("writes exception", func(t *testing.T) {
+		s := NewServer(WithFunctionHandler(0x41, func(w ResponseWriter, r *FunctionRequest) {
+			w.WriteException(SlaveDeviceBusy)
+		}))
+
+		response := s.handle(&Request{frame: newTestTCPFrame(0x41), diagnostics: newDiagnostics(s.diagnostics)})
+		assert.Equal(t, SlaveDeviceBusy, GetException(response))
+		assert.Equal(t, uint16(1), s.Diagnostics().Counters().ServerBusy)
+	})
+
+	t.Run("suppresses the response", func(t *testing.T) {
+		s := NewServer(WithFunctionHandler(0x41, func(w ResponseWriter, r *FunctionRequest) {
+			w.NoResponse()
+		}))
+
+		assert.Nil(t, s.handle(&Request{frame: newTestTCPFrame(0x41), diagnostics: newDiagnostics(s.diagnostics)}))
+		assert.Equal(t, uint16(1), s.Diagnostics().Counters().ServerNoResponses)
+	})
+
+	t.Run("unit handler takes precedence", func(t *testing.T) {
+		s := NewServer(
+			WithRegisterFunction(0x41, func(Register, Framer) ([]byte, Exception) { return []byte{1}, Success }),
+			WithUnit(1, nil, WithUnitFunctionHandler(0x41, func(w ResponseWriter, r *FunctionRequest) {
+				w.WriteData([]byte{2})
+			})),
+			WithUnit(2, nil),
+		)
+
+		frame := newTestTCPFrame(0x41)
+		frame.Device = 1
+		assert.Equal(t, []byte{2}, s.handle(&Request{frame: frame}).GetData())
+		frame.Device = 2
+		assert.Equal(t, []byte{1}, s.handle(&Request{frame: frame}).GetData())
+	})
+
+	t.Run("adapts functions", func(t *testing.T) {
+		assert.Nil(t, AdaptFunction(nil))
+
+		mr := NewMemRegister()
+		mr.HoldingRegisters[0] = 0x0102
+		w := &response{}
+		frame := newTestTCPFrame(3)
+		SetDataWithRegisterAndNumber(frame, 0, 1)
+		AdaptFunction(readHoldingRegisters)(w, &FunctionRequest{Frame: frame, Register: mr})
+		assert.Equal(t, []byte{2, 1, 2}, w.data)
+
+		w = &response{}
+		SetDataWithRegisterAndNumber(frame, 65535, 2)
+		AdaptFunction(readHoldingRegisters)(w, &FunctionRequest{Frame: frame, Register: mr})
+		assert.Equal(t, IllegalDataAddress, w.exception)
+	})
+}
+
+func TestFunctionRequestInfo(t *testing.T) {
+	requests := make(chan FunctionRequest, 1)
+	s := NewServer(WithFunctionHandler(0x41, func(w ResponseWriter, r *FunctionRequest) {
+		requests <- *r
+	}))
+	t.Cleanup(func() { s.Close() })
+
+	clientConn, serverConn := net.Pipe()
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	t.Cleanup(func() { client.Close() })
+	go s.ServeConn(tls.Server(serverConn, newTestTLSConfig(t)))
+
+	before := time.Now()
+	_, err := client.Write([]byte{0, 1, 0, 0, 0, 3, 7, 0x41, 0})
+	require.NoError(t, err)
+	_, err = io.ReadFull(client, make([]byte, 8))
+	require.NoError(t, err)
+
+	r := <-requests
+	assert.Equal(t, TransportTLS, r.Transport)
+	assert.Equal(t, uint8(7), r.UnitID)
+	assert.NotNil(t, r.RemoteAddr)
+	require.NotNil(t, r.TLS)
+	assert.True(t, r.TLS.HandshakeComplete)
+	assert.False(t, r.ReceivedAt.Before(before))
+}
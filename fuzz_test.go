@@ -0,0 +1,129 @@
+package mbserver
+
+import (
+	"context"
+	"testing"
+)
+
+func FuzzNewTCPFrame(f *testing.F) {
+	f.Add([]byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1})
+	f.Add([]byte{0, 1, 0, 0, 0, 3, 1, 3, 0})
+	f.Add([]byte{0, 1, 0, 0, 0, 2, 1, 7})
+
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		frame, err := NewTCPFrame(packet)
+		if err != nil {
+			return
+		}
+		frame.Copy().SetException(IllegalDataValue)
+		frame.Bytes()
+	})
+}
+
+func FuzzNewRTUFrame(f *testing.F) {
+	f.Add(rtuBytes(1, 3, []byte{0, 0, 0, 1}))
+	f.Add(rtuBytes(1, 7, nil))
+	f.Add([]byte{1, 3, 0})
+
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		rtuRequestLength(packet)
+
+		frame, err := NewRTUFrame(packet)
+		if err != nil {
+			return
+		}
+		frame.Copy().SetException(IllegalDataValue)
+		frame.Bytes()
+	})
+}
+
+func FuzzNewASCIIFrame(f *testing.F) {
+	f.Add([]byte(":010300000001FB\r\n"))
+	f.Add([]byte(":01\r\n"))
+
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		nextASCIIFrame(packet)
+
+		frame, err := NewASCIIFrame(packet)
+		if err != nil {
+			return
+		}
+		frame.Copy().SetException(IllegalDataValue)
+		frame.Bytes()
+	})
+}
+
+// fuzzFunction runs the built-in handler of funcCode on arbitrary request data,
+// with and without the specification checks. Unlike Server.handle, the call
+// does not recover from panics.
+func fuzzFunction(f *testing.F, funcCode uint8, seeds ...[]byte) {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0})
+	f.Add([]byte{0, 0, 0, 1, 2, 0, 1})
+
+	newServer := func(opts ...OptionFunc) *Server {
+		mr := NewMemRegister()
+		mr.DeclareFIFO(0)
+		mr.PushFIFO(0, 1, 2, 3)
+		opts = append(opts,
+			WithRegister(mr),
+			WithFileStore(NewMemFileStore()),
+			WithDeviceIdentification(DeviceIdentification{VendorName: "vendor", ProductName: "product"}),
+		)
+		return NewServer(opts...)
+	}
+	servers := []*Server{newServer(), newServer(WithLenientValidation())}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, s := range servers {
+			frame := newTestTCPFrame(funcCode)
+			frame.Data = data
+			s.call(context.Background(), &s.defaultUnit, s.diagnostics, frame)
+		}
+	})
+}
+
+func FuzzReadCoils(f *testing.F) { fuzzFunction(f, 1, []byte{0, 0, 0, 8}) }
+
+func FuzzReadDiscreteInputs(f *testing.F) { fuzzFunction(f, 2, []byte{0, 0, 0, 8}) }
+
+func FuzzReadHoldingRegisters(f *testing.F) { fuzzFunction(f, 3, []byte{0, 0, 0, 2}) }
+
+func FuzzReadInputRegisters(f *testing.F) { fuzzFunction(f, 4, []byte{0, 0, 0, 2}) }
+
+func FuzzWriteSingleCoil(f *testing.F) { fuzzFunction(f, 5, []byte{0, 1, 0xFF, 0}) }
+
+func FuzzWriteSingleRegister(f *testing.F) { fuzzFunction(f, 6, []byte{0, 1, 0, 7}) }
+
+func FuzzReadExceptionStatus(f *testing.F) { fuzzFunction(f, 7) }
+
+func FuzzDiagnostic(f *testing.F) { fuzzFunction(f, 8, []byte{0, 0, 1, 2}, []byte{0, 1, 0, 0}) }
+
+func FuzzGetCommEventCounter(f *testing.F) { fuzzFunction(f, 11) }
+
+func FuzzGetCommEventLog(f *testing.F) { fuzzFunction(f, 12) }
+
+func FuzzWriteMultipleCoils(f *testing.F) { fuzzFunction(f, 15, []byte{0, 0, 0, 9, 2, 0xFF, 1}) }
+
+func FuzzWriteMultipleRegisters(f *testing.F) { fuzzFunction(f, 16, []byte{0, 0, 0, 1, 2, 0, 7}) }
+
+func FuzzReportServerID(f *testing.F) { fuzzFunction(f, 17) }
+
+func FuzzReadFileRecord(f *testing.F) { fuzzFunction(f, 20, []byte{7, 6, 0, 1, 0, 0, 0, 2}) }
+
+func FuzzWriteFileRecord(f *testing.F) { fuzzFunction(f, 21, []byte{9, 6, 0, 1, 0, 0, 0, 1, 0, 7}) }
+
+func FuzzMaskWriteRegister(f *testing.F) { fuzzFunction(f, 22, []byte{0, 4, 0, 0xF2, 0, 0x25}) }
+
+func FuzzReadWriteMultipleRegisters(f *testing.F) {
+	fuzzFunction(f, 23, []byte{0, 0, 0, 1, 0, 0, 0, 1, 2, 0, 7})
+}
+
+func FuzzReadFIFOQueue(f *testing.F) { fuzzFunction(f, 24, []byte{0, 0}) }
+
+func FuzzReadDeviceIdentification(f *testing.F) {
+	fuzzFunction(f, 43, []byte{0x0E, 1, 0}, []byte{0x0E, 4, 0x80})
+}
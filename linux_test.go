@@ -4,11 +4,15 @@
 package mbserver
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/goburrow/modbus"
 	"github.com/goburrow/serial"
@@ -58,8 +62,8 @@ func TestModbusRTU(t *testing.T) {
 		Timeout:  10 * time.Second})
 	require.NoError(t, err)
 
-	t.Cleanup(s.Shutdown)
-	go s.Start()
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	go s.Start(context.Background())
 
 	// Allow the server to start and avoid connection refused on the client.
 	time.Sleep(1 * time.Millisecond)
@@ -88,3 +92,43 @@ func TestModbusRTU(t *testing.T) {
 
 	assert.EqualValues(t, []byte{255, 1}, results)
 }
+
+// openPTY opens a pseudo terminal and returns its master and the path of its
+// slave device.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo terminals not available: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		t.Fatalf("unlock pseudo terminal: %v", errno)
+	}
+	var number uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number))); errno != 0 {
+		t.Fatalf("get pseudo terminal number: %v", errno)
+	}
+
+	return master, fmt.Sprintf("/dev/pts/%d", number)
+}
+
+func TestShutdownIdleASCIIPort(t *testing.T) {
+	_, slave := openPTY(t)
+
+	s := NewServer()
+	// Without a timeout reads on the port would block until data arrives.
+	require.NoError(t, s.ListenASCII(&serial.Config{Address: slave, BaudRate: 9600}))
+	go s.Start(context.Background())
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	require.NoError(t, s.Shutdown(ctx))
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,104 @@
+package mbserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+)
+
+// Attribute keys of the log records, the same on every transport.
+const (
+	logKeyTransport = "transport"
+	logKeyRemote    = "remote"
+	logKeyUnit      = "unit"
+	logKeyFunction  = "function"
+	logKeyException = "exception"
+	logKeyDuration  = "duration"
+	logKeyFrame     = "frame"
+	logKeyError     = "err"
+)
+
+// WithLogger sets the logger the server writes to, instead of slog.Default().
+// A nil logger discards the logs.
+//
+// Requests are logged at the debug level with their transport, remote
+// address, unit ID, function code, exception and duration.
+func WithLogger(logger *slog.Logger) OptionFunc {
+	return func(s *Server) {
+		if logger == nil {
+			logger = slog.New(slog.DiscardHandler)
+		}
+		s.logger = logger
+	}
+}
+
+// WithFrameDump logs every request and response frame as a hex dump, at the
+// debug level of the logger.
+func WithFrameDump() OptionFunc {
+	return func(s *Server) {
+		s.frameDump = true
+	}
+}
+
+// connLogger returns the logger of a connection, port or datagram.
+func (s *Server) connLogger(transport Transport, remote net.Addr) *slog.Logger {
+	return s.logger.With(logKeyTransport, transport, remoteAttr(remote))
+}
+
+// remoteAttr returns the attribute of a remote address, empty for none so
+// that handlers leave it out.
+func remoteAttr(remote net.Addr) slog.Attr {
+	if remote == nil {
+		return slog.Attr{}
+	}
+	return slog.String(logKeyRemote, remote.String())
+}
+
+// requestAttrs returns the attributes identifying request in the logs.
+func requestAttrs(request *Request) []any {
+	unit, _ := unitAddress(request.frame)
+
+	// The callers append to the attributes more than once.
+	return slices.Clip([]any{
+		logKeyTransport, request.transport,
+		remoteAttr(request.remoteAddr),
+		logKeyUnit, unit,
+		logKeyFunction, request.frame.GetFunction(),
+	})
+}
+
+// hexDump formats a frame as space separated hex bytes.
+func hexDump(frame []byte) string {
+	return fmt.Sprintf("% x", frame)
+}
+
+// logRequest logs the outcome of request, answered with response, nil when
+// none was sent, and dumps both frames in frame dump mode.
+func (s *Server) logRequest(request *Request, response Framer, duration time.Duration) {
+	ctx := context.Background()
+	if !s.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	attrs := requestAttrs(request)
+
+	if s.frameDump {
+		s.logger.DebugContext(ctx, "request frame", append(attrs, logKeyFrame, hexDump(request.frame.Bytes()))...)
+		if response != nil {
+			s.logger.DebugContext(ctx, "response frame", append(attrs, logKeyFrame, hexDump(response.Bytes()))...)
+		}
+	}
+
+	exception := Success
+	if response != nil {
+		exception = GetException(response)
+	}
+	s.logger.DebugContext(ctx, "request handled", append(attrs,
+		logKeyException, exception.String(),
+		logKeyDuration, duration,
+		"responded", response != nil,
+	)...)
+}
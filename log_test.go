@@ -0,0 +1,141 @@
+package mbserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logRecords decodes the records written by a slog.JSONHandler.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		var record map[string]any
+		require.NoError(t, decoder.Decode(&record))
+		records = append(records, record)
+	}
+	return records
+}
+
+// findRecord returns the first record with message msg.
+func findRecord(records []map[string]any, msg string) map[string]any {
+	for _, record := range records {
+		if record[slog.MessageKey] == msg {
+			return record
+		}
+	}
+	return nil
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	s := NewServer(WithLogger(logger), WithFrameDump())
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.ServeConn(server)
+
+	client.SetDeadline(time.Now().Add(time.Second))
+	_, err := client.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0xFF, 0xFF, 0x00, 0x02})
+	require.NoError(t, err)
+	_, err = io.ReadFull(client, make([]byte, 9))
+	require.NoError(t, err)
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	records := logRecords(t, &buf)
+
+	handled := findRecord(records, "request handled")
+	require.NotNil(t, handled)
+	assert.Equal(t, "tcp", handled[logKeyTransport])
+	assert.Equal(t, "pipe", handled[logKeyRemote])
+	assert.EqualValues(t, 1, handled[logKeyUnit])
+	assert.EqualValues(t, 3, handled[logKeyFunction])
+	assert.Equal(t, "IllegalDataAddress", handled[logKeyException])
+	assert.Contains(t, handled, logKeyDuration)
+
+	request := findRecord(records, "request frame")
+	require.NotNil(t, request)
+	assert.Equal(t, "00 01 00 00 00 06 01 03 ff ff 00 02", request[logKeyFrame])
+
+	response := findRecord(records, "response frame")
+	require.NotNil(t, response)
+	assert.Equal(t, "00 01 00 00 00 03 01 83 02", response[logKeyFrame])
+}
+
+func TestWithLoggerRoutesTransportLogs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	s := NewServer(WithLogger(logger))
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.ServeConn(server)
+
+	// A frame with a non-zero protocol identifier is dropped and logged.
+	client.SetDeadline(time.Now().Add(time.Second))
+	_, err := client.Write([]byte{0x00, 0x01, 0x00, 0x01, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	records := logRecords(t, &buf)
+
+	record := findRecord(records, "bad MBAP frame")
+	require.NotNil(t, record)
+	assert.Equal(t, "tcp", record[logKeyTransport])
+	assert.Equal(t, "pipe", record[logKeyRemote])
+	assert.Contains(t, record[logKeyError], "invalid protocol identifier")
+
+	// Requests are only logged at the debug level.
+	assert.Nil(t, findRecord(records, "request handled"))
+}
+
+func TestWithNilLogger(t *testing.T) {
+	s := NewServer(WithLogger(nil), WithFrameDump())
+
+	request := &Request{
+		conn:  &testNetConn{},
+		frame: &TCPFrame{Device: 1, Function: 3, Data: []byte{0x00, 0x00, 0x00, 0x01}},
+	}
+	assert.NotPanics(t, func() { s.respond(request) })
+}
+
+func TestWithLoggerConnectionLimits(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	s := NewServer(WithLogger(logger), WithMaxConns(1))
+	address := startTCPServer(t, s)
+
+	first, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer first.Close()
+	require.True(t, readHoldingRegister(first))
+
+	second, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer second.Close()
+	// The refused connection is closed without an answer.
+	assert.False(t, readHoldingRegister(second))
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	records := logRecords(t, &buf)
+
+	record := findRecord(records, "connection refused, connection limit reached")
+	require.NotNil(t, record)
+	assert.Equal(t, "tcp", record[logKeyTransport])
+	assert.Equal(t, second.LocalAddr().String(), record[logKeyRemote])
+}
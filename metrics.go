@@ -0,0 +1,288 @@
+package mbserver
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Frame error kinds.
+const (
+	// FrameErrorChecksum is a frame dropped because its CRC or LRC does not match.
+	FrameErrorChecksum = "crc"
+	// FrameErrorFraming is a frame dropped because it is too short, too long,
+	// badly delimited or has an invalid MBAP header.
+	FrameErrorFraming = "framing"
+)
+
+// durationBuckets are the upper bounds of the histogram buckets.
+var durationBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+}
+
+// RequestLabels identifies the requests counted together.
+type RequestLabels struct {
+	Function uint8
+	Unit     uint8
+}
+
+// FrameErrorLabels identifies the frame errors counted together. Port is the
+// device address of a serial port opened by ListenRTU or ListenASCII, and
+// empty otherwise.
+type FrameErrorLabels struct {
+	Transport Transport
+	Port      string
+	Kind      string
+}
+
+// Histogram is a snapshot of a duration histogram. Counts[i] is the number of
+// observations less than or equal to Bounds[i]; Count includes those above
+// the last bound.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// MetricsSnapshot holds the values of the server metrics at a point in time.
+type MetricsSnapshot struct {
+	// Requests counts the requests handled by function code and unit ID.
+	Requests map[RequestLabels]uint64
+	// Exceptions counts the exception responses by exception code.
+	Exceptions map[Exception]uint64
+	// FrameErrors counts the frames dropped as malformed.
+	FrameErrors map[FrameErrorLabels]uint64
+
+	// TCPConnections is the number of TCP connections being served.
+	TCPConnections int
+	// QueueDepth is the number of requests waiting for the handler.
+	QueueDepth int
+
+	// QueueWait is the time requests spent waiting for the handler. Requests
+	// are only queued with ConcurrencySerial, the histogram stays empty with
+	// ConcurrencyPerConnection.
+	QueueWait Histogram
+	// Latency is the time taken to handle requests, by function code.
+	Latency map[uint8]Histogram
+}
+
+// Metrics collects the request, error and latency metrics of a server.
+type Metrics struct {
+	server *Server
+
+	tcpConns atomic.Int64
+
+	mu          sync.Mutex
+	requests    map[RequestLabels]uint64
+	exceptions  map[Exception]uint64
+	frameErrors map[FrameErrorLabels]uint64
+	queueWait   histogram
+	latency     map[uint8]*histogram
+}
+
+func newMetrics(s *Server) *Metrics {
+	return &Metrics{
+		server:      s,
+		requests:    make(map[RequestLabels]uint64),
+		exceptions:  make(map[Exception]uint64),
+		frameErrors: make(map[FrameErrorLabels]uint64),
+		latency:     make(map[uint8]*histogram),
+	}
+}
+
+// Metrics returns the metrics of the server.
+func (s *Server) Metrics() *Metrics {
+	return s.metrics
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(durationBuckets))
+	}
+	for i, bound := range durationBuckets {
+		if d <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += d
+}
+
+func (h *histogram) snapshot() Histogram {
+	counts := make([]uint64, len(durationBuckets))
+	copy(counts, h.counts)
+	return Histogram{Bounds: durationBuckets, Counts: counts, Count: h.count, Sum: h.sum}
+}
+
+// handled records a request answered with response, nil when none was sent,
+// after waiting wait in the queue and taking latency to handle.
+func (m *Metrics) handled(request *Request, response Framer, wait, latency time.Duration) {
+	unit, _ := unitAddress(request.frame)
+	funcCode := request.frame.GetFunction()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[RequestLabels{Function: funcCode, Unit: unit}]++
+	if response != nil {
+		if exception := GetException(response); exception != Success {
+			m.exceptions[exception]++
+		}
+	}
+
+	if !request.queued.IsZero() {
+		m.queueWait.observe(wait)
+	}
+
+	h := m.latency[funcCode]
+	if h == nil {
+		h = &histogram{}
+		m.latency[funcCode] = h
+	}
+	h.observe(latency)
+}
+
+// frameError records a frame dropped because of err.
+func (m *Metrics) frameError(transport Transport, port string, err error) {
+	kind := FrameErrorFraming
+	if errors.Is(err, errCRC) || errors.Is(err, errLRC) {
+		kind = FrameErrorChecksum
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.frameErrors[FrameErrorLabels{Transport: transport, Port: port, Kind: kind}]++
+}
+
+// Snapshot returns the current values of the metrics.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latency := make(map[uint8]Histogram, len(m.latency))
+	for funcCode, h := range m.latency {
+		latency[funcCode] = h.snapshot()
+	}
+
+	return MetricsSnapshot{
+		Requests:       maps.Clone(m.requests),
+		Exceptions:     maps.Clone(m.exceptions),
+		FrameErrors:    maps.Clone(m.frameErrors),
+		TCPConnections: int(m.tcpConns.Load()),
+		QueueDepth:     len(m.server.requestChan),
+		QueueWait:      m.queueWait.snapshot(),
+		Latency:        latency,
+	}
+}
+
+// Handler returns an http.Handler serving the metrics in the Prometheus text
+// exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		buf := bufio.NewWriter(w)
+		m.Snapshot().writePrometheus(buf)
+		buf.Flush()
+	})
+}
+
+// writePrometheus writes the snapshot in the Prometheus text exposition
+// format, series sorted by labels.
+func (snapshot MetricsSnapshot) writePrometheus(w *bufio.Writer) {
+	header := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	header("mbserver_requests_total", "counter", "Requests handled, by function code and unit ID.")
+	requests := slices.SortedFunc(maps.Keys(snapshot.Requests), func(a, b RequestLabels) int {
+		return cmp.Or(cmp.Compare(a.Function, b.Function), cmp.Compare(a.Unit, b.Unit))
+	})
+	for _, labels := range requests {
+		fmt.Fprintf(w, "mbserver_requests_total{function=\"%d\",unit=\"%d\"} %d\n", labels.Function, labels.Unit, snapshot.Requests[labels])
+	}
+
+	header("mbserver_exceptions_total", "counter", "Exception responses, by exception code.")
+	for _, exception := range slices.Sorted(maps.Keys(snapshot.Exceptions)) {
+		fmt.Fprintf(w, "mbserver_exceptions_total{code=\"%d\",exception=\"%s\"} %d\n", exception, exception, snapshot.Exceptions[exception])
+	}
+
+	header("mbserver_frame_errors_total", "counter", "Frames dropped as malformed, by transport, serial port and kind.")
+	frameErrors := slices.SortedFunc(maps.Keys(snapshot.FrameErrors), func(a, b FrameErrorLabels) int {
+		return cmp.Or(cmp.Compare(a.Transport, b.Transport), cmp.Compare(a.Port, b.Port), cmp.Compare(a.Kind, b.Kind))
+	})
+	for _, labels := range frameErrors {
+		fmt.Fprintf(w, "mbserver_frame_errors_total{transport=\"%s\",port=\"%s\",kind=\"%s\"} %d\n",
+			escapeLabel(string(labels.Transport)), escapeLabel(labels.Port), labels.Kind, snapshot.FrameErrors[labels])
+	}
+
+	header("mbserver_tcp_connections", "gauge", "TCP connections being served.")
+	fmt.Fprintf(w, "mbserver_tcp_connections %d\n", snapshot.TCPConnections)
+
+	header("mbserver_queue_depth", "gauge", "Requests waiting for the handler.")
+	fmt.Fprintf(w, "mbserver_queue_depth %d\n", snapshot.QueueDepth)
+
+	header("mbserver_queue_wait_seconds", "histogram", "Time requests spent waiting for the handler, with serial concurrency only.")
+	snapshot.QueueWait.writePrometheus(w, "mbserver_queue_wait_seconds", "")
+
+	header("mbserver_handler_duration_seconds", "histogram", "Time taken to handle requests, by function code.")
+	for _, funcCode := range slices.Sorted(maps.Keys(snapshot.Latency)) {
+		labels := fmt.Sprintf("function=\"%d\",", funcCode)
+		snapshot.Latency[funcCode].writePrometheus(w, "mbserver_handler_duration_seconds", labels)
+	}
+}
+
+// writePrometheus writes the series of a histogram, labels being the other
+// labels of the series followed by a comma.
+func (h Histogram) writePrometheus(w *bufio.Writer, name, labels string) {
+	for i, bound := range h.Bounds {
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatSeconds(bound), h.Counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.Count)
+
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatSeconds(h.Sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.Count)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// escapeLabel escapes a label value of the text exposition format.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,140 @@
+package mbserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	s := NewServer()
+	t.Cleanup(func() { s.Close() })
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.ServeConn(server)
+
+	client.SetDeadline(time.Now().Add(time.Second))
+	exchange := func(request []byte, responseLength int) {
+		t.Helper()
+		_, err := client.Write(request)
+		require.NoError(t, err)
+		_, err = io.ReadFull(client, make([]byte, responseLength))
+		require.NoError(t, err)
+	}
+
+	exchange([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x02}, 13)
+	exchange([]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0xFF, 0xFF, 0x00, 0x02}, 9)
+	exchange([]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x03, 0x02, 0x63, 0x00}, 9)
+
+	// A frame with a non-zero protocol identifier is dropped.
+	_, err := client.Write([]byte{0x00, 0x04, 0x00, 0x01, 0x00, 0x03, 0x01, 0x03, 0x00})
+	require.NoError(t, err)
+
+	// Metrics are recorded once the response is written.
+	require.Eventually(t, func() bool {
+		snapshot := s.Metrics().Snapshot()
+		return len(snapshot.FrameErrors) == 1 && snapshot.QueueWait.Count == 3
+	}, time.Second, 10*time.Millisecond)
+
+	snapshot := s.Metrics().Snapshot()
+	assert.Equal(t, map[RequestLabels]uint64{
+		{Function: 3, Unit: 1}:  2,
+		{Function: 99, Unit: 2}: 1,
+	}, snapshot.Requests)
+	assert.Equal(t, map[Exception]uint64{IllegalDataAddress: 1, IllegalFunction: 1}, snapshot.Exceptions)
+	assert.Equal(t, map[FrameErrorLabels]uint64{
+		{Transport: TransportTCP, Kind: FrameErrorFraming}: 1,
+	}, snapshot.FrameErrors)
+	assert.Equal(t, 1, snapshot.TCPConnections)
+	assert.Equal(t, 0, snapshot.QueueDepth)
+	assert.EqualValues(t, 3, snapshot.QueueWait.Count)
+	require.Contains(t, snapshot.Latency, uint8(3))
+	assert.EqualValues(t, 2, snapshot.Latency[3].Count)
+	assert.EqualValues(t, 2, snapshot.Latency[3].Counts[len(durationBuckets)-1])
+
+	client.Close()
+	require.Eventually(t, func() bool {
+		return s.Metrics().Snapshot().TCPConnections == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMetricsQueueWaitPerConnection(t *testing.T) {
+	s := NewServer(WithConcurrency(ConcurrencyPerConnection))
+
+	frame := newTestTCPFrame(3)
+	SetDataWithRegisterAndNumber(frame, 0, 1)
+	require.True(t, s.queue(&Request{conn: &testNetConn{}, frame: frame}, nil))
+
+	snapshot := s.Metrics().Snapshot()
+	assert.EqualValues(t, 1, snapshot.Latency[3].Count)
+	assert.EqualValues(t, 0, snapshot.QueueWait.Count)
+}
+
+func TestMetricsFrameErrors(t *testing.T) {
+	s := NewServer()
+	require.NoError(t, s.ListenRTUOverUDP("127.0.0.1:0"))
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	go s.Start(context.Background())
+
+	conn, err := net.Dial("udp", s.packetListeners[0].conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	bad := rtuBytes(1, 3, []byte{0, 1, 0, 1})
+	bad[len(bad)-1] ^= 0xFF
+	_, err = conn.Write(bad)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte{0x01, 0x03})
+	require.NoError(t, err)
+
+	want := map[FrameErrorLabels]uint64{
+		{Transport: TransportRTUOverUDP, Kind: FrameErrorChecksum}: 1,
+		{Transport: TransportRTUOverUDP, Kind: FrameErrorFraming}:  1,
+	}
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(want, s.Metrics().Snapshot().FrameErrors)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	s := NewServer()
+	s.Metrics().handled(&Request{
+		frame:  &TCPFrame{Device: 1, Function: 3},
+		queued: time.Now(),
+	}, &TCPFrame{Device: 1, Function: 0x83, Data: []byte{byte(IllegalDataAddress)}}, 2*time.Millisecond, 3*time.Millisecond)
+	s.Metrics().frameError(TransportRTU, `/dev/tty"0"`, errCRC)
+
+	recorder := httptest.NewRecorder()
+	s.Metrics().Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", recorder.Header().Get("Content-Type"))
+
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE mbserver_requests_total counter",
+		`mbserver_requests_total{function="3",unit="1"} 1`,
+		`mbserver_exceptions_total{code="2",exception="IllegalDataAddress"} 1`,
+		`mbserver_frame_errors_total{transport="rtu",port="/dev/tty\"0\"",kind="crc"} 1`,
+		"mbserver_tcp_connections 0",
+		"mbserver_queue_depth 0",
+		"# TYPE mbserver_queue_wait_seconds histogram",
+		`mbserver_queue_wait_seconds_bucket{le="0.001"} 0`,
+		`mbserver_queue_wait_seconds_bucket{le="0.0025"} 1`,
+		`mbserver_queue_wait_seconds_bucket{le="+Inf"} 1`,
+		"mbserver_queue_wait_seconds_sum 0.002",
+		"mbserver_queue_wait_seconds_count 1",
+		`mbserver_handler_duration_seconds_bucket{function="3",le="0.005"} 1`,
+		`mbserver_handler_duration_seconds_sum{function="3"} 0.003`,
+		`mbserver_handler_duration_seconds_count{function="3"} 1`,
+	} {
+		assert.Contains(t, strings.Split(body, "\n"), line)
+	}
+}
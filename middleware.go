@@ -0,0 +1,116 @@
+package mbserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// Handler executes a Modbus request and returns the response data, or the
+// exception to answer with.
+type Handler interface {
+	ServeModbus(ctx context.Context, frame Framer) ([]byte, Exception)
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(ctx context.Context, frame Framer) ([]byte, Exception)
+
+// ServeModbus calls f(ctx, frame).
+func (f HandlerFunc) ServeModbus(ctx context.Context, frame Framer) ([]byte, Exception) {
+	return f(ctx, frame)
+}
+
+// Middleware wraps a Handler to add behaviour around request execution. A
+// middleware may return an exception without calling next to reject a request.
+type Middleware func(next Handler) Handler
+
+// WithMiddleware wraps the execution of every request, by the built-in
+// functions as well as those set with WithRegisterFunction or WithUnitFunction,
+// in the given middlewares. The first middleware is the outermost one, and
+// middlewares of successive options are appended.
+//
+// The chain runs once per request: a broadcast request executed by several
+// units goes through it once.
+func WithMiddleware(middlewares ...Middleware) OptionFunc {
+	return func(s *Server) {
+		s.middlewares = append(s.middlewares, middlewares...)
+	}
+}
+
+// Transport identifies how a request reached the server.
+type Transport string
+
+const (
+	TransportTCP        Transport = "tcp"
+	TransportTLS        Transport = "tls"
+	TransportUDP        Transport = "udp"
+	TransportRTU        Transport = "rtu"
+	TransportASCII      Transport = "ascii"
+	TransportRTUOverTCP Transport = "rtu-over-tcp"
+	TransportRTUOverUDP Transport = "rtu-over-udp"
+)
+
+// RequestInfo describes the origin of a request.
+type RequestInfo struct {
+	// RemoteAddr is the address of the client, nil on serial lines.
+	RemoteAddr net.Addr
+	Transport  Transport
+	// UnitID is the unit identifier, or slave address, the request is sent
+	// to. It is 0 for serial line broadcasts.
+	UnitID uint8
+	// TLS is the state of the connection for requests received over TLS.
+	TLS *tls.ConnectionState
+	// ReceivedAt is the time the request frame was received.
+	ReceivedAt time.Time
+}
+
+type requestInfoKey struct{}
+
+// RequestInfoFromContext returns the RequestInfo of the request a Handler is
+// called for.
+func RequestInfoFromContext(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
+}
+
+type dispatchKey struct{}
+
+// dispatchTarget is what the end of the middleware chain executes a request
+// on. noResponse is set when the function handler suppressed the response.
+type dispatchTarget struct {
+	unit        *unit
+	broadcast   bool
+	diagnostics *Diagnostics
+	noResponse  bool
+}
+
+// dispatch is the Handler at the end of the middleware chain, it calls the
+// function of the unit the request is addressed to.
+func (s *Server) dispatch(ctx context.Context, frame Framer) ([]byte, Exception) {
+	target, _ := ctx.Value(dispatchKey{}).(*dispatchTarget)
+	if target == nil {
+		target = &dispatchTarget{}
+	}
+	diagnostics := target.diagnostics
+
+	if target.broadcast {
+		var exception Exception
+		for _, u := range s.broadcastUnits() {
+			if w := s.call(ctx, u, diagnostics, frame); w.exception != Success {
+				exception = w.exception
+			}
+		}
+		return nil, exception
+	}
+
+	u := target.unit
+	if u == nil {
+		u = &s.defaultUnit
+	}
+	w := s.call(ctx, u, diagnostics, frame)
+	if w.noResponse {
+		target.noResponse = true
+	}
+	return w.data, w.exception
+}
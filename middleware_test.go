@@ -0,0 +1,111 @@
+package mbserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, frame Framer) ([]byte, Exception) {
+				calls = append(calls, name)
+				return next.ServeModbus(ctx, frame)
+			})
+		}
+	}
+	custom := func(Register, Framer) ([]byte, Exception) {
+		calls = append(calls, "function")
+		return []byte{}, Success
+	}
+
+	s := NewServer(WithMiddleware(trace("first"), trace("second")), WithMiddleware(trace("third")), WithRegisterFunction(0x41, custom))
+
+	s.handle(&Request{frame: newTestTCPFrame(0x41)})
+	assert.Equal(t, []string{"first", "second", "third", "function"}, calls)
+
+	// Built-in functions go through the chain too.
+	calls = nil
+	frame := newTestTCPFrame(3)
+	SetDataWithRegisterAndNumber(frame, 0, 1)
+	response := s.handle(&Request{frame: frame})
+	assert.Equal(t, Success, GetException(response))
+	assert.Equal(t, []string{"first", "second", "third"}, calls)
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	mr := NewMemRegister()
+	readOnly := func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, frame Framer) ([]byte, Exception) {
+			if frame.GetFunction() == 6 {
+				return nil, IllegalFunction
+			}
+			return next.ServeModbus(ctx, frame)
+		})
+	}
+	s := NewServer(WithRegister(mr), WithMiddleware(readOnly))
+
+	frame := newTestTCPFrame(6)
+	SetDataWithRegisterAndNumber(frame, 1, 7)
+	response := s.handle(&Request{frame: frame})
+
+	assert.Equal(t, IllegalFunction, GetException(response))
+	assert.Equal(t, uint16(0), mr.HoldingRegisters[1])
+}
+
+func TestMiddlewareBroadcastRunsOnce(t *testing.T) {
+	calls := 0
+	count := func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, frame Framer) ([]byte, Exception) {
+			calls++
+			return next.ServeModbus(ctx, frame)
+		})
+	}
+	mr1, mr2 := NewMemRegister(), NewMemRegister()
+	s := NewServer(WithUnit(1, mr1), WithUnit(2, mr2), WithMiddleware(count))
+
+	frame := newTestRTUFrame(0, 6)
+	SetDataWithRegisterAndNumber(frame, 1, 7)
+	assert.Nil(t, s.handle(&Request{frame: frame}))
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, uint16(7), mr1.HoldingRegisters[1])
+	assert.Equal(t, uint16(7), mr2.HoldingRegisters[1])
+}
+
+func TestRequestInfoFromContext(t *testing.T) {
+	infos := make(chan RequestInfo, 1)
+	capture := func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, frame Framer) ([]byte, Exception) {
+			info, ok := RequestInfoFromContext(ctx)
+			assert.True(t, ok)
+			infos <- info
+			return next.ServeModbus(ctx, frame)
+		})
+	}
+	s := NewServer(WithMiddleware(capture))
+	t.Cleanup(func() { s.Close() })
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go s.ServeConn(server)
+
+	_, err := client.Write([]byte{0, 1, 0, 0, 0, 6, 5, 3, 0, 1, 0, 1})
+	require.NoError(t, err)
+	_, err = io.ReadFull(client, make([]byte, 11))
+	require.NoError(t, err)
+
+	info := <-infos
+	assert.Equal(t, TransportTCP, info.Transport)
+	assert.Equal(t, uint8(5), info.UnitID)
+	assert.Equal(t, server.RemoteAddr(), info.RemoteAddr)
+
+	_, ok := RequestInfoFromContext(context.Background())
+	assert.False(t, ok)
+}
@@ -1,5 +1,7 @@
 package mbserver
 
+import "sync"
+
 type Register interface {
 	ReadCoils(int, int) ([]bool, Exception)
 	ReadDiscreteInputs(int, int) ([]bool, Exception)
@@ -12,15 +14,46 @@ type Register interface {
 	WriteMultipleRegisters(int, []uint16) Exception
 }
 
+// FileStore is the storage behind Read File Record (function 20) and Write File
+// Record (function 21). Records are addressed by file number and record number
+// and hold one register each.
+type FileStore interface {
+	ReadFileRecord(file, record, length int) ([]uint16, Exception)
+	WriteFileRecord(file, record int, values []uint16) Exception
+}
+
+// MaskWriter is implemented by registers that can apply a Mask Write Register
+// (function 22) to a holding register as a single atomic read-modify-write.
+// Registers that do not implement it are read and written back by the handler,
+// which is only safe against other Modbus requests.
+type MaskWriter interface {
+	MaskWriteRegister(address int, andMask, orMask uint16) Exception
+}
+
+// FIFORegister is implemented by registers that expose FIFO queues to Read FIFO
+// Queue (function 24). The server detects it with a type assertion.
+type FIFORegister interface {
+	ReadFIFOQueue(address int) ([]uint16, Exception)
+}
+
 type MemRegister struct {
 	Coils          []bool
 	DiscreteInputs []bool
 
 	HoldingRegisters []uint16
 	InputRegisters   []uint16
+
+	// mu serializes writes to HoldingRegisters with MaskWriteRegister and
+	// guards fifos.
+	mu    sync.Mutex
+	fifos map[int][]uint16
 }
 
-var _ Register = (*MemRegister)(nil)
+var (
+	_ Register     = (*MemRegister)(nil)
+	_ MaskWriter   = (*MemRegister)(nil)
+	_ FIFORegister = (*MemRegister)(nil)
+)
 
 func NewMemRegister() *MemRegister {
 	return &MemRegister{
@@ -71,6 +104,8 @@ func (r *MemRegister) WriteSingleRegister(start int, value uint16) Exception {
 	if start >= len(r.HoldingRegisters) {
 		return IllegalDataAddress
 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.HoldingRegisters[start] = value
 	return Success
 }
@@ -89,8 +124,139 @@ func (r *MemRegister) WriteMultipleRegisters(start int, values []uint16) Excepti
 	if start+len(values) > len(r.HoldingRegisters) {
 		return IllegalDataAddress
 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	for i, value := range values {
 		r.HoldingRegisters[start+i] = value
 	}
 	return Success
 }
+
+// MaskWriteRegister applies (current AND andMask) OR (orMask AND NOT andMask)
+// to a holding register while holding the register lock.
+func (r *MemRegister) MaskWriteRegister(address int, andMask, orMask uint16) Exception {
+	if address >= len(r.HoldingRegisters) {
+		return IllegalDataAddress
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.HoldingRegisters[address] = maskRegister(r.HoldingRegisters[address], andMask, orMask)
+	return Success
+}
+
+// Lock acquires the lock used by the holding register writers. Code that
+// assigns HoldingRegisters directly while the server is running should hold
+// it so the assignment does not interleave with a Mask Write Register.
+func (r *MemRegister) Lock() {
+	r.mu.Lock()
+}
+
+// Unlock releases the lock acquired by Lock.
+func (r *MemRegister) Unlock() {
+	r.mu.Unlock()
+}
+
+// DeclareFIFO declares an empty FIFO queue at the FIFO pointer address.
+func (r *MemRegister) DeclareFIFO(address int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fifos == nil {
+		r.fifos = make(map[int][]uint16)
+	}
+	if _, ok := r.fifos[address]; !ok {
+		r.fifos[address] = []uint16{}
+	}
+}
+
+// PushFIFO appends values to the FIFO queue declared at address.
+func (r *MemRegister) PushFIFO(address int, values ...uint16) Exception {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queue, ok := r.fifos[address]
+	if !ok {
+		return IllegalDataAddress
+	}
+	r.fifos[address] = append(queue, values...)
+	return Success
+}
+
+// PopFIFO removes and returns the oldest value of the FIFO queue declared at
+// address. It reports false when the queue is empty or not declared.
+func (r *MemRegister) PopFIFO(address int) (uint16, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queue := r.fifos[address]
+	if len(queue) == 0 {
+		return 0, false
+	}
+	r.fifos[address] = queue[1:]
+	return queue[0], true
+}
+
+// ReadFIFOQueue returns the content of the FIFO queue declared at address
+// without removing it.
+func (r *MemRegister) ReadFIFOQueue(address int) ([]uint16, Exception) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queue, ok := r.fifos[address]
+	if !ok {
+		return nil, IllegalDataAddress
+	}
+	return append([]uint16(nil), queue...), Success
+}
+
+func maskRegister(current, andMask, orMask uint16) uint16 {
+	return (current & andMask) | (orMask &^ andMask)
+}
+
+// maxFileRecords is the number of records addressable in a file.
+const maxFileRecords = 10000
+
+// MemFileStore is a FileStore that keeps files in memory. Files are created
+// and grown by writes, up to 10000 records.
+type MemFileStore struct {
+	Files map[int][]uint16
+
+	mu sync.Mutex
+}
+
+var _ FileStore = (*MemFileStore)(nil)
+
+func NewMemFileStore() *MemFileStore {
+	return &MemFileStore{
+		Files: make(map[int][]uint16),
+	}
+}
+
+func (f *MemFileStore) ReadFileRecord(file, record, length int) ([]uint16, Exception) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	records, ok := f.Files[file]
+	if !ok || record+length > len(records) {
+		return nil, IllegalDataAddress
+	}
+	return append([]uint16(nil), records[record:record+length]...), Success
+}
+
+func (f *MemFileStore) WriteFileRecord(file, record int, values []uint16) Exception {
+	if record+len(values) > maxFileRecords {
+		return IllegalDataAddress
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Files == nil {
+		f.Files = make(map[int][]uint16)
+	}
+
+	records := f.Files[file]
+	if len(records) < record+len(values) {
+		records = append(records, make([]uint16, record+len(values)-len(records))...)
+	}
+	copy(records[record:], values)
+	f.Files[file] = records
+
+	return Success
+}
@@ -1,6 +1,7 @@
 package mbserver
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -226,6 +227,73 @@ func TestMemRegister_WriteMultipleRegisters(t *testing.T) {
 	}
 }
 
+func TestMemRegister_MaskWriteRegister(t *testing.T) {
+	t.Run("applies masks", func(t *testing.T) {
+		mr := NewMemRegister()
+		mr.HoldingRegisters[4] = 0x12
+
+		exc := mr.MaskWriteRegister(4, 0xF2, 0x25)
+		require.Equal(t, Success, exc)
+		assert.Equal(t, uint16(0x17), mr.HoldingRegisters[4])
+	})
+
+	t.Run("out of bounds", func(t *testing.T) {
+		mr := NewMemRegister()
+
+		exc := mr.MaskWriteRegister(65536, 0xF2, 0x25)
+		require.Equal(t, IllegalDataAddress, exc)
+	})
+
+	t.Run("concurrent bit updates are not lost", func(t *testing.T) {
+		mr := NewMemRegister()
+
+		var wg sync.WaitGroup
+		for bit := range 16 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 100 {
+					mask := uint16(1) << bit
+					mr.MaskWriteRegister(0, ^mask, mask)
+					mr.MaskWriteRegister(0, ^mask, 0)
+					mr.MaskWriteRegister(0, ^mask, mask)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, uint16(0xFFFF), mr.HoldingRegisters[0])
+	})
+}
+
+func TestMemRegister_FIFO(t *testing.T) {
+	mr := NewMemRegister()
+
+	require.Equal(t, IllegalDataAddress, mr.PushFIFO(10, 1))
+
+	mr.DeclareFIFO(10)
+	values, exc := mr.ReadFIFOQueue(10)
+	require.Equal(t, Success, exc)
+	assert.Empty(t, values)
+
+	require.Equal(t, Success, mr.PushFIFO(10, 1, 2, 3))
+	value, ok := mr.PopFIFO(10)
+	require.True(t, ok)
+	assert.Equal(t, uint16(1), value)
+
+	values, exc = mr.ReadFIFOQueue(10)
+	require.Equal(t, Success, exc)
+	assert.Equal(t, []uint16{2, 3}, values)
+
+	// Declaring an existing queue keeps its content.
+	mr.DeclareFIFO(10)
+	values, _ = mr.ReadFIFOQueue(10)
+	assert.Equal(t, []uint16{2, 3}, values)
+
+	_, ok = mr.PopFIFO(11)
+	assert.False(t, ok)
+}
+
 func TestMemRegister_Init(t *testing.T) {
 	mr := NewMemRegister()
 
@@ -263,3 +331,34 @@ func TestMemRegister_Init(t *testing.T) {
 		})
 	}
 }
+
+func TestMemFileStore(t *testing.T) {
+	fs := NewMemFileStore()
+
+	t.Run("read missing file", func(t *testing.T) {
+		_, exc := fs.ReadFileRecord(1, 0, 1)
+		require.Equal(t, IllegalDataAddress, exc)
+	})
+
+	t.Run("write grows file", func(t *testing.T) {
+		exc := fs.WriteFileRecord(1, 3, []uint16{10, 20})
+		require.Equal(t, Success, exc)
+		assert.Equal(t, []uint16{0, 0, 0, 10, 20}, fs.Files[1])
+	})
+
+	t.Run("read written records", func(t *testing.T) {
+		values, exc := fs.ReadFileRecord(1, 2, 3)
+		require.Equal(t, Success, exc)
+		assert.Equal(t, []uint16{0, 10, 20}, values)
+	})
+
+	t.Run("read beyond file", func(t *testing.T) {
+		_, exc := fs.ReadFileRecord(1, 4, 2)
+		require.Equal(t, IllegalDataAddress, exc)
+	})
+
+	t.Run("write beyond record limit", func(t *testing.T) {
+		exc := fs.WriteFileRecord(1, 9999, []uint16{1, 2})
+		require.Equal(t, IllegalDataAddress, exc)
+	})
+}
@@ -0,0 +1,145 @@
+package mbserver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// maxASCIIFrameLength is the length of an ASCII frame carrying a 253 byte PDU:
+// colon, hex encoded address, PDU and LRC, then CR LF.
+const maxASCIIFrameLength = 1 + 2*(1+253+1) + 2
+
+// ListenASCII starts the Modbus ASCII server listening to a serial device.
+// For example:  err := s.ListenASCII(&serial.Config{Address: "/dev/ttyUSB0"})
+// The read timeout of the configuration is raised to at least 10ms so that an
+// idle port is polled for shutdown; it bounds how long Shutdown waits for the
+// port.
+func (s *Server) ListenASCII(serialConfig *serial.Config) (err error) {
+	config := *serialConfig
+	config.Timeout = max(serialConfig.Timeout, minSerialReadTimeout)
+
+	port, err := serial.Open(&config)
+	if err != nil {
+		return fmt.Errorf("failed to open serial port %s: %w", serialConfig.Address, err)
+	}
+	s.asciiPorts = append(s.asciiPorts, asciiPort{port: port, name: serialConfig.Address})
+
+	return nil
+}
+
+// asciiPort is a serial port opened by ListenASCII.
+type asciiPort struct {
+	port serial.Port
+	name string
+}
+
+func (s *Server) acceptASCIIRequests(port serial.Port, name string) error {
+	defer port.Close()
+
+	var inflight sync.WaitGroup
+	defer s.awaitResponses(&inflight)
+
+	diagnostics := newDiagnostics(s.diagnostics)
+	logger := s.connLogger(TransportASCII, nil)
+
+	var pending []byte
+	buffer := make([]byte, 512)
+
+	for {
+		select {
+		case <-s.closeSignalChan:
+			return nil
+		default:
+			// A timeout or EOF is an idle line, the loop then checks for shutdown.
+			bytesRead, err := port.Read(buffer)
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, serial.ErrTimeout) {
+				return err
+			}
+
+			pending = append(pending, buffer[:bytesRead]...)
+
+			for {
+				var packet []byte
+				packet, pending = nextASCIIFrame(pending)
+				if packet == nil {
+					break
+				}
+
+				frame, err := NewASCIIFrame(packet)
+				if err != nil {
+					// Bad frames sent to other slaves of the line are not our errors.
+					if address, ok := asciiAddress(packet); ok && !s.servesAddress(address) {
+						continue
+					}
+
+					diagnostics.communicationError()
+					s.metrics.frameError(TransportASCII, name, err)
+
+					logger.Warn("bad ASCII frame", logKeyError, err)
+
+					continue
+				}
+
+				request := &Request{
+					conn:        port,
+					frame:       frame,
+					diagnostics: diagnostics,
+					transport:   TransportASCII,
+					received:    time.Now(),
+				}
+
+				if !s.queue(request, &inflight) {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// asciiAddress decodes the address of an ASCII frame, which may be invalid
+// otherwise. It reports false when the address is not valid hex.
+func asciiAddress(packet []byte) (uint8, bool) {
+	if len(packet) < 3 {
+		return 0, false
+	}
+	address, err := hex.DecodeString(string(packet[1:3]))
+	if err != nil {
+		return 0, false
+	}
+	return address[0], true
+}
+
+// nextASCIIFrame extracts the first complete frame, from a colon to the next
+// CR LF pair, from buffered serial data and returns it with the remaining data.
+// Bytes outside a frame are discarded, and a colon always starts a new frame.
+func nextASCIIFrame(pending []byte) (packet, rest []byte) {
+	for {
+		start := bytes.IndexByte(pending, ':')
+		if start < 0 {
+			return nil, pending[:0]
+		}
+		pending = pending[start:]
+
+		end := bytes.Index(pending, []byte("\r\n"))
+		restart := bytes.IndexByte(pending[1:], ':')
+
+		switch {
+		case restart >= 0 && (end < 0 || restart+1 < end):
+			// A new frame started before the current one ended.
+			pending = pending[restart+1:]
+		case end >= 0:
+			return pending[:end+2], pending[end+2:]
+		case len(pending) > maxASCIIFrameLength:
+			return nil, pending[:0]
+		default:
+			return nil, pending
+		}
+	}
+}
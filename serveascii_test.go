@@ -0,0 +1,102 @@
+package mbserver
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptASCIIRequests(t *testing.T) {
+	t.Run("reassembles fragmented and merged frames", func(t *testing.T) {
+		s := NewServer()
+		port := &testSerialPort{}
+		port.steps = []serialReadStep{
+			{data: []byte("garbage:0103")},
+			{data: []byte("00000001FB\r")},
+			{data: []byte("\n:010400000001FA\r\n")},
+			{err: io.EOF, after: func() { close(s.closeSignalChan) }},
+		}
+
+		err := s.acceptASCIIRequests(port, "")
+		require.NoError(t, err)
+		require.Len(t, s.requestChan, 2)
+
+		request := <-s.requestChan
+		assert.EqualValues(t, 3, request.frame.GetFunction())
+		request = <-s.requestChan
+		assert.EqualValues(t, 4, request.frame.GetFunction())
+		assert.True(t, port.closed)
+	})
+
+	t.Run("restarts on colon inside frame", func(t *testing.T) {
+		s := NewServer()
+		port := &testSerialPort{}
+		port.steps = []serialReadStep{
+			{data: []byte(":0103:010300000001FB\r\n")},
+			{err: io.EOF, after: func() { close(s.closeSignalChan) }},
+		}
+
+		err := s.acceptASCIIRequests(port, "")
+		require.NoError(t, err)
+		require.Len(t, s.requestChan, 1)
+		assert.Equal(t, uint16(0), s.Diagnostics().Counters().BusCommunicationErrors)
+	})
+
+	t.Run("counts LRC errors", func(t *testing.T) {
+		s := NewServer()
+		port := &testSerialPort{}
+		port.steps = []serialReadStep{
+			{data: []byte(":010300000001FC\r\n")},
+			{err: io.EOF, after: func() { close(s.closeSignalChan) }},
+		}
+
+		err := s.acceptASCIIRequests(port, "")
+		require.NoError(t, err)
+		assert.Equal(t, 0, len(s.requestChan))
+		assert.Equal(t, uint16(1), s.Diagnostics().Counters().BusCommunicationErrors)
+	})
+
+	t.Run("ignores LRC errors of other slaves", func(t *testing.T) {
+		s := NewServer(WithUnit(1, nil))
+		port := &testSerialPort{}
+		port.steps = []serialReadStep{
+			{data: []byte(":020300000001FC\r\n")},
+			{err: io.EOF, after: func() { close(s.closeSignalChan) }},
+		}
+
+		err := s.acceptASCIIRequests(port, "")
+		require.NoError(t, err)
+		assert.Equal(t, 0, len(s.requestChan))
+		assert.Equal(t, uint16(0), s.Diagnostics().Counters().BusCommunicationErrors)
+		assert.Empty(t, s.Metrics().Snapshot().FrameErrors)
+	})
+}
+
+func TestNextASCIIFrame(t *testing.T) {
+	packet, rest := nextASCIIFrame([]byte("xx:0103"))
+	assert.Nil(t, packet)
+	assert.Equal(t, []byte(":0103"), rest)
+
+	packet, rest = nextASCIIFrame([]byte(":01\r\n:02"))
+	assert.Equal(t, []byte(":01\r\n"), packet)
+	assert.Equal(t, []byte(":02"), rest)
+
+	packet, rest = nextASCIIFrame(append([]byte(":"), make([]byte, maxASCIIFrameLength)...))
+	assert.Nil(t, packet)
+	assert.Empty(t, rest)
+}
+
+func TestASCIIResponse(t *testing.T) {
+	mr := NewMemRegister()
+	s := NewServer(WithRegister(mr))
+	mr.HoldingRegisters[0] = 10
+
+	frame, err := NewASCIIFrame([]byte(":010300000001FB\r\n"))
+	require.NoError(t, err)
+
+	response := s.handle(&Request{frame: frame})
+	require.NotNil(t, response)
+	assert.Equal(t, []byte(":010302000AF0\r\n"), response.Bytes())
+}
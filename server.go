@@ -2,33 +2,121 @@
 package mbserver
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"io"
+	"log/slog"
 	"net"
+	"runtime/debug"
 	"sync"
-
-	"github.com/goburrow/serial"
+	"sync/atomic"
+	"time"
 )
 
+// ErrServerClosed is returned by Start and the Serve methods after a call to
+// Shutdown or Close.
+var ErrServerClosed = errors.New("mbserver: server closed")
+
 // Server is a Modbus slave with allocated memory for discrete inputs, coils, etc.
 type Server struct {
-	listeners []net.Listener
-	ports     []serial.Port
+	listeners       []net.Listener
+	rtuListeners    []net.Listener
+	packetListeners []packetListener
+	ports           []rtuPort
+	asciiPorts      []asciiPort
 
+	// wg counts the goroutines reading requests from a connection or a port.
 	wg              sync.WaitGroup
 	closeSignalChan chan struct{}
+	closeOnce       sync.Once
+	// forceCloseChan is closed when connections are closed without waiting
+	// for the responses still pending.
+	forceCloseChan chan struct{}
+	forceCloseOnce sync.Once
+
+	// mu guards started and the listeners and connections being served.
+	mu              sync.Mutex
+	started         bool
+	activeListeners map[net.Listener]struct{}
+	activeConns     map[io.Closer]struct{}
+
+	// tcpConns and connsPerIP count the accepted TCP connections, guarded by mu.
+	tcpConns        map[*connState]struct{}
+	connsPerIP      map[string]int
+	idleTimeout     time.Duration
+	maxConns        int
+	maxConnsPerIP   int
+	connLimitPolicy ConnLimitPolicy
+	onConnect       func(net.Conn)
+	onDisconnect    func(net.Conn)
+	writeTimeout    time.Duration
+	errorHandler    func(error)
+
+	middlewares []Middleware
+	chain       Handler
+
+	lenient       bool
+	strictFraming bool
 
-	requestChan chan *Request
+	logger    *slog.Logger
+	frameDump bool
 
-	function [256]Function
+	concurrency    Concurrency
+	pipelineWindow int
+	requestChan    chan *Request
+	handlerOnce    sync.Once
+	handling       atomic.Bool
+	drainChan      chan struct{}
+	drainOnce      sync.Once
+	handlerDone    chan struct{}
 
-	register Register
+	function [256]FunctionHandler
+
+	register  Register
+	fileStore FileStore
+
+	defaultUnit          unit
+	units                [256]*unit
+	routing              bool
+	unknownUnitException Exception
+
+	diagnostics *Diagnostics
+	metrics     *Metrics
+
+	deviceObjects   []deviceObject
+	conformityLevel uint8
+
+	serverID        []byte
+	runIndicator    byte
+	exceptionStatus func(Register) uint8
 }
 
 // Request contains the connection and Modbus frame.
 type Request struct {
 	conn  io.ReadWriteCloser
 	frame Framer
+
+	// diagnostics is the serial link the request arrived on, nil for network transports.
+	diagnostics *Diagnostics
+
+	// pending counts the requests of the connection waiting for a response.
+	pending *sync.WaitGroup
+	// window holds a slot of the in-flight window of the connection, freed
+	// once the request is answered.
+	window chan struct{}
+
+	transport  Transport
+	remoteAddr net.Addr
+	tlsState   *tls.ConnectionState
+	received   time.Time
+	// queued is when the request was queued for the handler, zero when it
+	// was handled at once.
+	queued time.Time
+
+	// rejected is the exception answering a malformed request without
+	// processing it, Success for a valid request.
+	rejected Exception
 }
 
 // OptionFunc is a function type used to configure options for the Server.
@@ -38,7 +126,7 @@ type OptionFunc func(s *Server)
 // Parameter funcCode is the function code, and function is the custom handler for that code.
 func WithRegisterFunction(funcCode uint8, function Function) OptionFunc {
 	return func(s *Server) {
-		s.function[funcCode] = function
+		s.function[funcCode] = AdaptFunction(function)
 	}
 }
 
@@ -49,39 +137,190 @@ func WithRegister(register Register) OptionFunc {
 	}
 }
 
+// Concurrency selects how the server executes requests.
+type Concurrency int
+
+const (
+	// ConcurrencySerial handles the requests of every connection and port one
+	// at a time, in a single goroutine. It is the default.
+	ConcurrencySerial Concurrency = iota
+	// ConcurrencyPerConnection handles the requests of each connection or port
+	// in order, and different connections in parallel. A UDP socket counts as
+	// one connection. Registers, file stores and custom functions must be safe
+	// for concurrent use, see SyncMemRegister.
+	ConcurrencyPerConnection
+)
+
+// WithConcurrency sets the execution model of requests. Without a register
+// set by WithRegister, a server using ConcurrencyPerConnection is given a
+// SyncMemRegister instead of a MemRegister.
+func WithConcurrency(concurrency Concurrency) OptionFunc {
+	return func(s *Server) {
+		s.concurrency = concurrency
+	}
+}
+
+// defaultPipelineWindow is the number of requests of a TCP connection that
+// may wait for a response when WithPipelineWindow is not used.
+const defaultPipelineWindow = 8
+
+// WithPipelineWindow sets the number of requests a TCP connection may have
+// waiting for a response, read ahead of the responses of the previous ones.
+// Once it is reached, the connection is not read until a response is written.
+// Responses are always written in the order of the requests, carrying their
+// transaction identifiers. With ConcurrencyPerConnection each request is
+// answered before the next one is read, so the window does not apply.
+// Values below 1 are taken as 1.
+func WithPipelineWindow(n int) OptionFunc {
+	return func(s *Server) {
+		s.pipelineWindow = max(n, 1)
+	}
+}
+
+// WithLenientValidation disables the checks of the quantity, byte count and
+// coil value limits of the specification on functions 1, 2, 3, 4, 5, 6, 15 and
+// 16, for masters that do not respect them. Oversized reads then produce
+// responses whose byte count overflows.
+func WithLenientValidation() OptionFunc {
+	return func(s *Server) {
+		s.lenient = true
+	}
+}
+
+// WithStrictFraming closes TCP connections on a malformed MBAP header, an
+// invalid length or a non-zero protocol identifier, instead of dropping the
+// frame or answering it with an exception and carrying on.
+func WithStrictFraming() OptionFunc {
+	return func(s *Server) {
+		s.strictFraming = true
+	}
+}
+
+// WithFileStore sets the file store used by Read File Record (function 20) and
+// Write File Record (function 21). Without a file store both functions answer
+// IllegalFunction.
+func WithFileStore(store FileStore) OptionFunc {
+	return func(s *Server) {
+		s.fileStore = store
+	}
+}
+
+// WithServerID sets the server ID and run indicator returned by Report Server ID
+// (function 17). The ID is truncated to 250 bytes, the most that fit in a
+// 253 byte PDU along with the function code, byte count and run indicator.
+func WithServerID(id []byte, running bool) OptionFunc {
+	return func(s *Server) {
+		s.serverID = append([]byte(nil), id[:min(len(id), 250)]...)
+		s.runIndicator = 0x00
+		if running {
+			s.runIndicator = 0xFF
+		}
+	}
+}
+
+// WithExceptionStatus sets the callback that supplies the eight exception status
+// bits returned by Read Exception Status (function 7). Without it the status is zero.
+func WithExceptionStatus(status func(Register) uint8) OptionFunc {
+	return func(s *Server) {
+		s.exceptionStatus = status
+	}
+}
+
+// ExceptionStatusCoils returns an exception status callback that maps the eight
+// coils starting at address to the exception status bits, the first coil being
+// the least significant bit.
+func ExceptionStatusCoils(address int) func(Register) uint8 {
+	return func(r Register) uint8 {
+		coils, exception := r.ReadCoils(address, 8)
+		if exception != Success {
+			return 0
+		}
+
+		var status uint8
+		for i, value := range coils {
+			if value {
+				status |= 1 << i
+			}
+		}
+		return status
+	}
+}
+
 // NewServer creates a new Modbus server (slave).
 func NewServer(opts ...OptionFunc) *Server {
-	s := &Server{}
+	s := &Server{
+		logger:               slog.Default(),
+		pipelineWindow:       defaultPipelineWindow,
+		writeTimeout:         defaultWriteTimeout,
+		runIndicator:         0xFF,
+		unknownUnitException: GatewayTargetDeviceFailedToRespond,
+	}
 
 	// Add default functions.
-	s.function[1] = readCoils
-	s.function[2] = readDiscreteInputs
-	s.function[3] = readHoldingRegisters
-	s.function[4] = readInputRegisters
-	s.function[5] = writeSingleCoil
-	s.function[6] = writeSingleRegister
-	s.function[15] = writeMultipleCoils
-	s.function[16] = writeMultipleRegisters
+	s.function[1] = AdaptFunction(s.validated(checkRead(maxReadBits), readCoils))
+	s.function[2] = AdaptFunction(s.validated(checkRead(maxReadBits), readDiscreteInputs))
+	s.function[3] = AdaptFunction(s.validated(checkRead(maxReadRegisters), readHoldingRegisters))
+	s.function[4] = AdaptFunction(s.validated(checkRead(maxReadRegisters), readInputRegisters))
+	s.function[5] = AdaptFunction(s.validated(checkWriteSingleCoil, writeSingleCoil))
+	s.function[6] = AdaptFunction(s.validated(checkWriteSingleRegister, writeSingleRegister))
+	s.function[7] = AdaptFunction(s.readExceptionStatus)
+	s.function[15] = AdaptFunction(s.validated(checkWriteMultiple(maxWriteBits, 1), writeMultipleCoils))
+	s.function[16] = AdaptFunction(s.validated(checkWriteMultiple(maxWriteRegisters, 16), writeMultipleRegisters))
+	s.function[17] = AdaptFunction(s.reportServerID)
+	s.function[20] = AdaptFunction(s.readFileRecord)
+	s.function[21] = AdaptFunction(s.writeFileRecord)
+	s.function[22] = AdaptFunction(maskWriteRegister)
+	s.function[23] = AdaptFunction(readWriteMultipleRegisters)
+	s.function[24] = AdaptFunction(readFIFOQueue)
 
 	for _, opt := range opts {
 		opt(s)
 	}
 
 	if s.register == nil {
-		s.register = &MemRegister{
-			Coils:            make([]bool, 65536),
-			DiscreteInputs:   make([]bool, 65536),
-			HoldingRegisters: make([]uint16, 65536),
-			InputRegisters:   make([]uint16, 65536),
+		s.register = s.newRegister()
+	}
+	s.defaultUnit.register = s.register
+	for _, u := range s.units {
+		if u != nil && u.register == nil {
+			u.register = s.newRegister()
 		}
 	}
 
+	s.chain = HandlerFunc(s.dispatch)
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		s.chain = s.middlewares[i](s.chain)
+	}
+
+	s.diagnostics = newDiagnostics(nil)
 	s.requestChan = make(chan *Request, 10)
+	s.metrics = newMetrics(s)
 	s.closeSignalChan = make(chan struct{})
+	s.forceCloseChan = make(chan struct{})
+	s.drainChan = make(chan struct{})
+	s.handlerDone = make(chan struct{})
 
 	return s
 }
 
+// newRegister returns the register of the server or of a unit added without
+// one: a SyncMemRegister under ConcurrencyPerConnection, a MemRegister
+// otherwise.
+func (s *Server) newRegister() Register {
+	if s.concurrency == ConcurrencyPerConnection {
+		return NewSyncMemRegister()
+	}
+	return NewMemRegister()
+}
+
+// Diagnostics returns the server wide diagnostic counters and event log, which
+// accumulate those of the serial ports.
+func (s *Server) Diagnostics() *Diagnostics {
+	return s.diagnostics
+}
+
+// handle processes a request and returns the response, or nil when no
+// response must be sent.
 func (s *Server) handle(request *Request) Framer {
 	var (
 		exception Exception
@@ -91,64 +330,431 @@ func (s *Server) handle(request *Request) Framer {
 		funcCode = request.frame.GetFunction()
 	)
 
-	if s.function[funcCode] != nil {
-		data, exception = s.function[funcCode](s.register, request.frame)
-		response.SetData(data)
-	} else {
-		exception = IllegalFunction
+	diagnostics := request.diagnostics
+
+	address, serialLine := unitAddress(request.frame)
+	broadcast := serialLine && address == 0
+
+	u := s.unit(address)
+	if u == nil && !broadcast {
+		diagnostics.notAddressed()
+
+		// Other slaves may answer on a shared serial line.
+		if serialLine || s.unknownUnitException == Success {
+			return nil
+		}
+		response.SetException(s.unknownUnitException)
+		return response
+	}
+
+	// In listen only mode requests are monitored but neither processed nor answered.
+	listenOnly := diagnostics.received(broadcast)
+	if listenOnly && !isRestartCommunications(request.frame) {
+		diagnostics.completed(funcCode, Success, false)
+		return nil
+	}
+
+	// Only writes are meaningful without a response, other broadcasts are ignored.
+	if broadcast && !isBroadcastFunction(funcCode) {
+		diagnostics.completed(funcCode, Success, false)
+		return nil
+	}
+
+	if request.rejected != Success {
+		diagnostics.completed(funcCode, request.rejected, true)
+		response.SetException(request.rejected)
+		return response
+	}
+
+	ctx := context.WithValue(context.Background(), requestInfoKey{}, RequestInfo{
+		RemoteAddr: request.remoteAddr,
+		Transport:  request.transport,
+		UnitID:     address,
+		TLS:        request.tlsState,
+		ReceivedAt: request.received,
+	})
+	target := &dispatchTarget{unit: u, broadcast: broadcast, diagnostics: diagnostics}
+	ctx = context.WithValue(ctx, dispatchKey{}, target)
+
+	data, exception = s.serveModbus(ctx, request.frame)
+
+	// Broadcast requests are executed by every unit and never answered.
+	if broadcast {
+		diagnostics.completed(funcCode, exception, false)
+		return nil
 	}
 
 	if !errors.Is(exception, Success) {
 		response.SetException(exception)
+	} else {
+		response.SetData(data)
 	}
 
+	if listenOnly || target.noResponse || diagnostics.ListenOnly() {
+		diagnostics.completed(funcCode, exception, false)
+		return nil
+	}
+	diagnostics.completed(funcCode, exception, true)
+
 	return response
 }
 
+// serveModbus runs the middleware chain and the function handler, turning a
+// panic in either into a SlaveDeviceFailure so that it does not take down the
+// server.
+func (s *Server) serveModbus(ctx context.Context, frame Framer) (data []byte, exception Exception) {
+	defer func() {
+		if r := recover(); r != nil {
+			info, _ := RequestInfoFromContext(ctx)
+			s.logger.Error("panic while handling request",
+				logKeyTransport, info.Transport,
+				remoteAttr(info.RemoteAddr),
+				logKeyUnit, info.UnitID,
+				logKeyFunction, frame.GetFunction(),
+				"panic", r,
+				"stack", string(debug.Stack()),
+			)
+			data, exception = nil, SlaveDeviceFailure
+		}
+	}()
+
+	return s.chain.ServeModbus(ctx, frame)
+}
+
+// respond handles request and writes its response, if any.
+func (s *Server) respond(request *Request) {
+	start := time.Now()
+	response := s.handle(request)
+	latency := time.Since(start)
+
+	if response != nil {
+		if err := s.write(request, response.Bytes()); err != nil {
+			s.writeFailed(request, err)
+		}
+	}
+
+	s.metrics.handled(request, response, start.Sub(request.queued), latency)
+	s.logRequest(request, response, latency)
+
+	if request.window != nil {
+		<-request.window
+	}
+	if request.pending != nil {
+		request.pending.Done()
+	}
+}
+
 // All requests are handled synchronously to prevent modbus memory corruption.
 func (s *Server) handler() {
+	defer close(s.handlerDone)
+
 	for {
 		select {
-		case <-s.closeSignalChan:
-			return
 		case request := <-s.requestChan:
-			response := s.handle(request)
-			request.conn.Write(response.Bytes())
+			s.respond(request)
+		case <-s.drainChan:
+			// Answer the requests queued before the readers stopped.
+			for {
+				select {
+				case request := <-s.requestChan:
+					s.respond(request)
+				default:
+					return
+				}
+			}
 		}
 	}
 }
 
-// Start the service
-func (s *Server) Start() {
+// startHandler starts the goroutine processing the requests, once.
+func (s *Server) startHandler() {
+	s.handlerOnce.Do(func() {
+		s.handling.Store(true)
+		go s.handler()
+	})
+}
+
+// drain asks the handler to return once the queued requests are answered.
+func (s *Server) drain() {
+	// A handler that never started has nothing to answer.
+	s.handlerOnce.Do(func() { close(s.handlerDone) })
+	s.drainOnce.Do(func() { close(s.drainChan) })
+}
+
+// queue hands request to the handler and counts it in pending until it is
+// answered, or answers it at once with ConcurrencyPerConnection. It reports
+// false if the server is shutting down.
+func (s *Server) queue(request *Request, pending *sync.WaitGroup) bool {
+	if s.concurrency == ConcurrencyPerConnection {
+		s.respond(request)
+		return true
+	}
+
+	request.pending = pending
+	request.queued = time.Now()
+	pending.Add(1)
+
+	select {
+	case s.requestChan <- request:
+		return true
+	case <-s.closeSignalChan:
+		pending.Done()
+		return false
+	}
+}
+
+// reserve waits for a free slot in the in-flight window of a connection and
+// gives it to request. It reports false if the server is shutting down.
+func (s *Server) reserve(request *Request, window chan struct{}) bool {
+	if s.concurrency == ConcurrencyPerConnection {
+		return true
+	}
+
+	select {
+	case window <- struct{}{}:
+		request.window = window
+		return true
+	case <-s.closeSignalChan:
+		return false
+	}
+}
+
+// awaitResponses waits until the requests counted in pending are answered, so
+// that a connection is not closed before its last responses are written.
+func (s *Server) awaitResponses(pending *sync.WaitGroup) {
+	if !s.handling.Load() {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-s.handlerDone:
+	case <-s.forceCloseChan:
+	}
+}
+
+// Start serves the listeners and ports opened by the Listen methods until the
+// server is shut down or one of them fails. On failure the server is closed
+// and the error returned; when ctx is done the server is closed and ctx.Err()
+// returned. After Shutdown or Close, Start returns ErrServerClosed.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closing() {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	if s.started {
+		s.mu.Unlock()
+		return errors.New("server already started")
+	}
+	s.started = true
+	s.mu.Unlock()
+
+	errChan := make(chan error, len(s.listeners)+len(s.rtuListeners)+len(s.packetListeners)+len(s.ports)+len(s.asciiPorts))
+	run := func(serve func() error) {
+		go func() { errChan <- serve() }()
+	}
+	// runConn serves c, tracked so that Shutdown can interrupt it.
+	runConn := func(c io.Closer, serve func() error) {
+		if !s.trackConn(c) {
+			return
+		}
+		run(func() error {
+			defer s.releaseConn(c)
+			return serve()
+		})
+	}
+
 	for _, listener := range s.listeners {
-		go s.accept(listener)
+		run(func() error { return s.accept(listener) })
+	}
+
+	for _, listener := range s.rtuListeners {
+		run(func() error { return s.acceptConns(listener, TransportRTUOverTCP, s.serveRTUOverTCP) })
+	}
+
+	for _, listener := range s.packetListeners {
+		runConn(listener.conn, func() error { return s.acceptPackets(listener) })
 	}
 
 	for _, port := range s.ports {
-		s.wg.Add(1)
-		go func() {
-			defer s.wg.Done()
+		runConn(port.port, func() error { return s.acceptSerialRequests(port.port, port.name, port.timing) })
+	}
 
-			s.acceptSerialRequests(port)
-		}()
+	for _, port := range s.asciiPorts {
+		runConn(port.port, func() error { return s.acceptASCIIRequests(port.port, port.name) })
 	}
 
-	s.handler()
+	s.startHandler()
+
+	for {
+		select {
+		case err := <-errChan:
+			if err == nil {
+				continue
+			}
+			if s.closing() {
+				return ErrServerClosed
+			}
+			s.Close()
+			return err
+		case <-s.closeSignalChan:
+			return ErrServerClosed
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		}
+	}
 }
 
-// Shutdown stops listening to TCP/IP ports and closes serial ports.
-func (s *Server) Shutdown() {
-	close(s.closeSignalChan)
+// closing reports whether Shutdown or Close has been called.
+func (s *Server) closing() bool {
+	select {
+	case <-s.closeSignalChan:
+		return true
+	default:
+		return false
+	}
+}
 
-	s.wg.Wait()
+// trackListener registers a listener passed to Serve to be closed on
+// shutdown. It reports false if the server is already shutting down.
+func (s *Server) trackListener(listen net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	//close the listeners
-	for _, listener := range s.listeners {
-		listener.Close()
+	if s.closing() {
+		return false
+	}
+	if s.activeListeners == nil {
+		s.activeListeners = make(map[net.Listener]struct{})
+	}
+	s.activeListeners[listen] = struct{}{}
+	return true
+}
+
+func (s *Server) releaseListener(listen net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.activeListeners, listen)
+}
+
+// trackConn registers a connection or a port being read, counted in the wait
+// group Shutdown waits on until releaseConn is called. It reports false if the
+// server is already shutting down.
+func (s *Server) trackConn(c io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing() {
+		return false
 	}
+	if s.activeConns == nil {
+		s.activeConns = make(map[io.Closer]struct{})
+	}
+	s.activeConns[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) releaseConn(c io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.activeConns, c)
+	s.wg.Done()
+}
 
-	//close the ports
+// beginClose stops accepting requests: the listeners are closed and the reads
+// in progress on connections are interrupted.
+func (s *Server) beginClose() {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		close(s.closeSignalChan)
+
+		for _, listener := range s.listeners {
+			listener.Close()
+		}
+		for _, listener := range s.rtuListeners {
+			listener.Close()
+		}
+		for listener := range s.activeListeners {
+			listener.Close()
+		}
+
+		for c := range s.activeConns {
+			if conn, ok := c.(interface{ SetReadDeadline(time.Time) error }); ok {
+				conn.SetReadDeadline(time.Now())
+			}
+		}
+	})
+}
+
+// closeConns closes the connections and ports still open.
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.activeConns {
+		c.Close()
+	}
+	for _, listener := range s.packetListeners {
+		listener.conn.Close()
+	}
 	for _, port := range s.ports {
-		port.Close()
+		port.port.Close()
+	}
+	for _, port := range s.asciiPorts {
+		port.port.Close()
 	}
 }
+
+// Shutdown gracefully shuts down the server: it stops accepting connections
+// and requests, waits for the requests already received to be answered, then
+// closes the connections and ports. If ctx is done first, the connections are
+// closed at once and ctx.Err() is returned.
+//
+// Shutdown may be called several times, and after Close.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.beginClose()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		s.drain()
+		<-s.handlerDone
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.closeConns()
+		return nil
+	case <-ctx.Done():
+		s.forceClose()
+		return ctx.Err()
+	}
+}
+
+// Close immediately closes the listeners, connections and ports, without
+// waiting for pending responses. It may be called several times.
+func (s *Server) Close() error {
+	s.beginClose()
+	s.forceClose()
+	return nil
+}
+
+func (s *Server) forceClose() {
+	s.forceCloseOnce.Do(func() { close(s.forceCloseChan) })
+	s.closeConns()
+	s.drain()
+}
@@ -1,6 +1,10 @@
 package mbserver
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net"
 	"testing"
 	"time"
 
@@ -46,8 +50,8 @@ func TestModbus(t *testing.T) {
 	s := NewServer(WithRegister(mr))
 	err := s.ListenTCP("127.0.0.1:3333")
 	require.NoError(t, err)
-	t.Cleanup(s.Shutdown)
-	go s.Start()
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	go s.Start(context.Background())
 
 	// Allow the server to start and to avoid a connection refused on the client
 	time.Sleep(1 * time.Millisecond)
@@ -117,3 +121,152 @@ func TestModbus(t *testing.T) {
 		assert.Equal(t, expect, got)
 	})
 }
+
+func TestShutdownWaitsForPendingResponses(t *testing.T) {
+	handling := make(chan struct{})
+	release := make(chan struct{})
+	slow := func(_ Register, frame Framer) ([]byte, Exception) {
+		close(handling)
+		<-release
+		return []byte{0x42}, Success
+	}
+	s := NewServer(WithRegisterFunction(0x41, slow))
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go s.ServeConn(server)
+
+	_, err := client.Write([]byte{0, 1, 0, 0, 0, 3, 1, 0x41, 0})
+	require.NoError(t, err)
+	<-handling
+
+	shutdown := make(chan error)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	// The response is written before the connection is closed.
+	close(release)
+	response := make([]byte, 9)
+	_, err = io.ReadFull(client, response)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 0, 0, 0, 3, 1, 0x41, 0x42}, response)
+
+	assert.NoError(t, <-shutdown)
+	_, err = client.Read(response)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	s := NewServer()
+
+	// A port that blocks on reads and cannot be interrupted.
+	reader, writer := io.Pipe()
+	t.Cleanup(func() { writer.Close() })
+	served := make(chan error)
+	go func() {
+		served <- s.ServeRTU(struct {
+			io.Reader
+			io.Writer
+			io.Closer
+		}{reader, io.Discard, reader}, 0)
+	}()
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.activeConns) == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	assert.ErrorIs(t, <-served, ErrServerClosed)
+}
+
+func TestServerLifecycle(t *testing.T) {
+	t.Run("repeated Shutdown and Close", func(t *testing.T) {
+		s := NewServer()
+		require.NoError(t, s.ListenTCP("127.0.0.1:0"))
+
+		started := make(chan error)
+		go func() { started <- s.Start(context.Background()) }()
+
+		assert.NoError(t, s.Shutdown(context.Background()))
+		assert.NoError(t, s.Shutdown(context.Background()))
+		assert.NoError(t, s.Close())
+		assert.ErrorIs(t, <-started, ErrServerClosed)
+		assert.ErrorIs(t, s.Start(context.Background()), ErrServerClosed)
+	})
+
+	t.Run("Start returns the first fatal error", func(t *testing.T) {
+		s := NewServer()
+		s.listeners = append(s.listeners, &testNetListener{acceptFn: func() (net.Conn, error) {
+			return nil, errors.New("accept failure")
+		}})
+
+		err := s.Start(context.Background())
+		assert.ErrorContains(t, err, "accept failure")
+		assert.True(t, s.closing())
+	})
+
+	t.Run("Start closes the server when the context is done", func(t *testing.T) {
+		s := NewServer()
+		require.NoError(t, s.ListenTCP("127.0.0.1:0"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		assert.ErrorIs(t, s.Start(ctx), context.Canceled)
+		_, err := s.listeners[0].Accept()
+		assert.ErrorIs(t, err, net.ErrClosed)
+	})
+
+	t.Run("Start twice", func(t *testing.T) {
+		s := NewServer()
+		t.Cleanup(func() { s.Close() })
+
+		go s.Start(context.Background())
+		require.Eventually(t, func() bool {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return s.started
+		}, time.Second, time.Millisecond)
+
+		assert.ErrorContains(t, s.Start(context.Background()), "already started")
+	})
+}
+
+func TestConcurrencyPerConnection(t *testing.T) {
+	release := make(chan struct{})
+	blocking := func(_ Register, frame Framer) ([]byte, Exception) {
+		<-release
+		return []byte{}, Success
+	}
+	s := NewServer(WithConcurrency(ConcurrencyPerConnection), WithRegisterFunction(0x41, blocking))
+	t.Cleanup(func() { s.Close() })
+	require.IsType(t, &SyncMemRegister{}, s.register)
+	require.Equal(t, Success, s.register.WriteSingleRegister(1, 0x1234))
+
+	slow, slowServer := net.Pipe()
+	t.Cleanup(func() { slow.Close() })
+	go s.ServeConn(slowServer)
+	fast, fastServer := net.Pipe()
+	t.Cleanup(func() { fast.Close() })
+	go s.ServeConn(fastServer)
+
+	_, err := slow.Write([]byte{0, 1, 0, 0, 0, 3, 1, 0x41, 0})
+	require.NoError(t, err)
+
+	// The other connection is answered while the first request is blocked.
+	require.NoError(t, fast.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = fast.Write([]byte{0, 2, 0, 0, 0, 6, 1, 3, 0, 1, 0, 1})
+	require.NoError(t, err)
+	response := make([]byte, 11)
+	_, err = io.ReadFull(fast, response)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 2, 0, 0, 0, 5, 1, 3, 2, 0x12, 0x34}, response)
+
+	close(release)
+	response = make([]byte, 8)
+	_, err = io.ReadFull(slow, response)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 0, 0, 0, 2, 1, 0x41}, response)
+}
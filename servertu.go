@@ -1,64 +1,293 @@
 package mbserver
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/goburrow/serial"
 )
 
+// errOverrun reports a frame longer than the maximum RTU frame length.
+var errOverrun = errors.New("character overrun")
+
+// minSerialReadTimeout bounds how often an idle serial port is polled to detect
+// the silence that ends a frame.
+const minSerialReadTimeout = 10 * time.Millisecond
+
+// rtuPort is a serial port opened by ListenRTU with the frame timing of its line.
+type rtuPort struct {
+	port   serial.Port
+	name   string
+	timing rtuTiming
+}
+
+// rtuTiming holds the inter-character (t1.5) and inter-frame (t3.5) silences of
+// a serial line.
+type rtuTiming struct {
+	t15 time.Duration
+	t35 time.Duration
+}
+
+// newRTUTiming computes the frame timing from the line settings. Above 19200
+// baud the fixed values of 750µs and 1.75ms are used, as the specification
+// recommends.
+func newRTUTiming(config *serial.Config) rtuTiming {
+	baudRate := config.BaudRate
+	if baudRate == 0 {
+		baudRate = 19200
+	}
+	if baudRate > 19200 {
+		return rtuTiming{t15: 750 * time.Microsecond, t35: 1750 * time.Microsecond}
+	}
+
+	dataBits := config.DataBits
+	if dataBits == 0 {
+		dataBits = 8
+	}
+	stopBits := config.StopBits
+	if stopBits == 0 {
+		stopBits = 1
+	}
+	parityBits := 1
+	if config.Parity == "N" {
+		parityBits = 0
+	}
+
+	// A character is a start bit, the data bits, the parity bit and the stop bits.
+	charTime := time.Duration(1+dataBits+parityBits+stopBits) * time.Second / time.Duration(baudRate)
+
+	return rtuTiming{t15: charTime * 3 / 2, t35: charTime * 7 / 2}
+}
+
 // ListenRTU starts the Modbus server listening to a serial device.
 // For example:  err := s.ListenRTU(&serial.Config{Address: "/dev/ttyUSB0"})
+// The read timeout of the configuration is replaced by the 3.5 character time
+// (at least 10ms), which is needed to detect the end of frames.
 func (s *Server) ListenRTU(serialConfig *serial.Config) (err error) {
-	port, err := serial.Open(serialConfig)
+	timing := newRTUTiming(serialConfig)
+
+	config := *serialConfig
+	config.Timeout = max(timing.t35, minSerialReadTimeout)
+
+	port, err := serial.Open(&config)
 	if err != nil {
 		return fmt.Errorf("failed to open serial port %s: %w", serialConfig.Address, err)
 	}
-	s.ports = append(s.ports, port)
+	s.ports = append(s.ports, rtuPort{port: port, name: serialConfig.Address, timing: timing})
 
 	return nil
 }
 
-func (s *Server) acceptSerialRequests(port serial.Port) error {
+// ServeRTU serves Modbus RTU requests on port, for example a serial device
+// opened and configured by the caller, until Shutdown is called or a read
+// fails, returning io.EOF when the stream ends. Frames are delimited with the
+// timing of a line at baudRate with 11 bit characters, 19200 baud when zero;
+// a read that returns no data or serial.ErrTimeout is taken as silence on the
+// line. The port is closed when ServeRTU returns. After Shutdown or Close,
+// ServeRTU returns ErrServerClosed.
+func (s *Server) ServeRTU(port io.ReadWriteCloser, baudRate int) error {
+	if !s.trackConn(port) {
+		port.Close()
+		return ErrServerClosed
+	}
+	defer s.releaseConn(port)
+
+	s.startHandler()
+
+	err := s.acceptSerialRequests(port, "", newRTUTiming(&serial.Config{BaudRate: baudRate}))
+	if s.closing() {
+		// The read may have failed because the port was closed on shutdown.
+		return ErrServerClosed
+	}
+	return err
+}
+
+func (s *Server) acceptSerialRequests(port io.ReadWriteCloser, name string, timing rtuTiming) error {
 	defer port.Close()
 
-SkipFrameError:
+	var inflight sync.WaitGroup
+	defer s.awaitResponses(&inflight)
+
+	diagnostics := newDiagnostics(s.diagnostics)
+	reader := newRTUReader(port, timing, diagnostics, s.connLogger(TransportRTU, nil))
+	reader.frameErrors = func(err error) { s.metrics.frameError(TransportRTU, name, err) }
+	reader.accept = s.servesAddress
+
 	for {
 		select {
 		case <-s.closeSignalChan:
 			return nil
 		default:
-			buffer := make([]byte, 512)
-
-			bytesRead, err := port.Read(buffer)
-			if err != nil && err != io.EOF {
+			frames, err := reader.read()
+			if err != nil {
 				return err
 			}
 
-			if bytesRead != 0 {
+			for _, frame := range frames {
+				request := &Request{
+					conn:        port,
+					frame:       frame,
+					diagnostics: diagnostics,
+					transport:   TransportRTU,
+					received:    time.Now(),
+				}
 
-				// Set the length of the packet to the number of read bytes.
-				packet := buffer[:bytesRead]
+				if !s.queue(request, &inflight) {
+					return nil
+				}
+			}
+		}
+	}
+}
 
-				frame, err := NewRTUFrame(packet)
-				if err != nil {
-					slog.Error("bad serial frame error", "err", err)
-					//The next line prevents RTU server from exiting when it receives a bad frame. Simply discard the erroneous
-					//frame and wait for next frame by jumping back to the beginning of the 'for' loop.
-					slog.Warn("Keep the RTU server running!!")
+// rtuReader delimits RTU frames in the byte stream of a serial port, which may
+// deliver a frame over several reads or several frames in a single read.
+//
+// The end of a frame is found by predicting its length from the function code.
+// When the length cannot be predicted, or the CRC does not match at the
+// predicted length as with the responses of other slaves on a multi-drop bus,
+// the frame ends after a t1.5 silence provided its CRC is valid. Data left
+// after a t3.5 silence is dropped as a bad frame, and after an overrun the
+// input is discarded until the line has been silent for t3.5.
+//
+// Silences are measured between the returns of Read, not between characters:
+// they include the latency of the driver, and cannot be detected more finely
+// than the read timeout of the port.
+type rtuReader struct {
+	port        io.Reader
+	timing      rtuTiming
+	diagnostics *Diagnostics
+	logger      *slog.Logger
+	// frameErrors, when set, is called with the error of each dropped frame.
+	frameErrors func(error)
+	// accept, when set, reports whether frames sent to address are served.
+	// Bad frames sent to other addresses are dropped without an error.
+	accept func(address uint8) bool
+	now    func() time.Time
 
-					continue SkipFrameError
-				}
+	buffer   []byte
+	pending  []byte
+	lastByte time.Time
+	discard  bool
+	// unpredicted is set when the frame in progress did not end at its
+	// predicted length, it then ends with a silence.
+	unpredicted bool
+}
 
-				request := &Request{port, frame}
+func newRTUReader(port io.Reader, timing rtuTiming, diagnostics *Diagnostics, logger *slog.Logger) *rtuReader {
+	return &rtuReader{
+		port:        port,
+		timing:      timing,
+		diagnostics: diagnostics,
+		logger:      logger,
+		now:         time.Now,
+		buffer:      make([]byte, 512),
+	}
+}
 
-				select {
-				case s.requestChan <- request:
-				case <-s.closeSignalChan:
-					return nil
-				}
+// read reads once from the port and returns the frames it completed. A read
+// timeout is silence on the line, any other error, io.EOF included, ends the
+// port.
+func (r *rtuReader) read() ([]*RTUFrame, error) {
+	bytesRead, err := r.port.Read(r.buffer)
+	if err != nil && !errors.Is(err, serial.ErrTimeout) {
+		return nil, err
+	}
+
+	return r.feed(r.buffer[:bytesRead], r.now()), nil
+}
+
+// inFrame reports whether part of a frame has been received.
+func (r *rtuReader) inFrame() bool {
+	return len(r.pending) > 0
+}
+
+// feed processes the data received at now, no data meaning the line has been
+// idle, and returns the frames it completed.
+func (r *rtuReader) feed(data []byte, now time.Time) []*RTUFrame {
+	silence := now.Sub(r.lastByte)
+
+	var frames []*RTUFrame
+
+	if len(data) == 0 {
+		if len(r.pending) > 0 && silence >= r.timing.t15 && (r.unpredicted || rtuRequestLength(r.pending) < 0) {
+			if frame, err := NewRTUFrame(r.pending); err == nil {
+				frames = append(frames, frame)
+				r.pending = nil
+				r.unpredicted = false
 			}
 		}
+		if silence >= r.timing.t35 {
+			frames = r.flush(frames)
+		}
+		return frames
+	}
+
+	if silence >= r.timing.t35 {
+		frames = r.flush(frames)
+	}
+	r.lastByte = now
+
+	if r.discard {
+		return frames
 	}
+	r.pending = append(r.pending, data...)
+
+	for !r.unpredicted {
+		length := rtuRequestLength(r.pending)
+		if length <= 0 || len(r.pending) < length {
+			break
+		}
+
+		frame, err := NewRTUFrame(r.pending[:length])
+		if err != nil {
+			// Not a request, or not of the predicted length: the frame is
+			// only known to be bad if it is still invalid once the line is silent.
+			r.unpredicted = true
+			break
+		}
+		frames = append(frames, frame)
+		r.pending = r.pending[length:]
+	}
+
+	if len(r.pending) > maxRTUFrameLength {
+		r.diagnostics.characterOverrun()
+		if r.frameErrors != nil {
+			r.frameErrors(errOverrun)
+		}
+		r.pending = nil
+		r.discard = true
+	}
+
+	return frames
+}
+
+// flush ends the frame in progress once the line has been silent for t3.5 and
+// appends it to frames when it is valid.
+func (r *rtuReader) flush(frames []*RTUFrame) []*RTUFrame {
+	if len(r.pending) > 0 && !r.discard {
+		if frame, err := NewRTUFrame(r.pending); err == nil {
+			frames = append(frames, frame)
+		} else if r.accept == nil || r.accept(r.pending[0]) {
+			r.frameError(err)
+		}
+	}
+	r.pending = nil
+	r.discard = false
+	r.unpredicted = false
+	return frames
+}
+
+// frameError records a frame dropped because of err.
+func (r *rtuReader) frameError(err error) {
+	r.diagnostics.communicationError()
+	if r.frameErrors != nil {
+		r.frameErrors(err)
+	}
+
+	r.logger.Warn("bad RTU frame", logKeyError, err)
 }
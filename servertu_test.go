@@ -1,9 +1,13 @@
 package mbserver
 
 import (
+	"context"
 	"errors"
 	"io"
+	"log/slog"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/goburrow/serial"
 	"github.com/stretchr/testify/assert"
@@ -60,7 +64,7 @@ func TestAcceptSerialRequests(t *testing.T) {
 			steps: []serialReadStep{{err: errors.New("read failure")}},
 		}
 
-		err := s.acceptSerialRequests(port)
+		err := s.acceptSerialRequests(port, "", rtuTiming{})
 		require.Error(t, err)
 		assert.ErrorContains(t, err, "read failure")
 		assert.True(t, port.closed)
@@ -71,12 +75,13 @@ func TestAcceptSerialRequests(t *testing.T) {
 		port := &testSerialPort{}
 		port.steps = []serialReadStep{
 			{data: []byte{0x01, 0x04, 0x02, 0xFF, 0xFF, 0xB8, 0x81}},
-			{err: io.EOF, after: func() { close(s.closeSignalChan) }},
+			{err: serial.ErrTimeout, after: func() { close(s.closeSignalChan) }},
 		}
 
-		err := s.acceptSerialRequests(port)
+		err := s.acceptSerialRequests(port, "", rtuTiming{})
 		require.NoError(t, err)
 		assert.Equal(t, 0, len(s.requestChan))
+		assert.Equal(t, uint16(1), s.Diagnostics().Counters().BusCommunicationErrors)
 		assert.True(t, port.closed)
 	})
 
@@ -85,16 +90,263 @@ func TestAcceptSerialRequests(t *testing.T) {
 		port := &testSerialPort{}
 		port.steps = []serialReadStep{
 			{data: []byte{0x01, 0x04, 0x02, 0xFF, 0xFF, 0xB8, 0x80}},
-			{err: io.EOF, after: func() { close(s.closeSignalChan) }},
+			{err: serial.ErrTimeout},
+			{err: serial.ErrTimeout, after: func() { close(s.closeSignalChan) }},
 		}
 
-		err := s.acceptSerialRequests(port)
+		err := s.acceptSerialRequests(port, "", rtuTiming{})
 		require.NoError(t, err)
 		require.Len(t, s.requestChan, 1)
 
 		request := <-s.requestChan
 		require.NotNil(t, request)
 		assert.EqualValues(t, 4, request.frame.GetFunction())
+		assert.NotNil(t, request.diagnostics)
 		assert.True(t, port.closed)
 	})
 }
+
+func TestNewRTUTiming(t *testing.T) {
+	timing := newRTUTiming(&serial.Config{BaudRate: 9600, DataBits: 8, StopBits: 1, Parity: "E"})
+	charTime := 11 * time.Second / 9600
+	assert.Equal(t, charTime*3/2, timing.t15)
+	assert.Equal(t, charTime*7/2, timing.t35)
+
+	// ServeRTU only knows the baud rate.
+	timing = newRTUTiming(&serial.Config{BaudRate: 2400})
+	assert.Equal(t, 11*time.Second/2400*7/2, timing.t35)
+
+	timing = newRTUTiming(&serial.Config{BaudRate: 115200})
+	assert.Equal(t, 750*time.Microsecond, timing.t15)
+	assert.Equal(t, 1750*time.Microsecond, timing.t35)
+}
+
+func TestRTURequestLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		expect int
+	}{
+		{"too short", []byte{0x01}, 0},
+		{"read holding registers", []byte{0x01, 0x03}, 8},
+		{"report server ID", []byte{0x01, 0x11}, 4},
+		{"write multiple registers without byte count", []byte{0x01, 0x10, 0x00, 0x01, 0x00, 0x02}, 0},
+		{"write multiple registers", []byte{0x01, 0x10, 0x00, 0x01, 0x00, 0x02, 0x04}, 13},
+		{"read write multiple registers", []byte{0x01, 0x17, 0, 0, 0, 1, 0, 0, 0, 1, 0x02}, 15},
+		{"read file record", []byte{0x01, 0x14, 0x07}, 12},
+		{"return query data", []byte{0x01, 0x08, 0x00, 0x00}, -1},
+		{"diagnostic counter", []byte{0x01, 0x08, 0x00, 0x0B}, 8},
+		{"read device identification", []byte{0x01, 0x2B, 0x0E}, 7},
+		{"unknown function", []byte{0x01, 0x41}, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, rtuRequestLength(tt.packet))
+		})
+	}
+}
+
+// newTestRTUReader returns a reader on a byte-by-byte fake port whose clock
+// advances by gap on every read.
+func newTestRTUReader(gap time.Duration, packets ...[]byte) (*rtuReader, *testSerialPort) {
+	clock := time.Unix(0, 0)
+
+	port := &testSerialPort{}
+	for _, packet := range packets {
+		if packet == nil {
+			// A nil packet stands for an idle read.
+			port.steps = append(port.steps, serialReadStep{err: serial.ErrTimeout, after: func() { clock = clock.Add(gap) }})
+			continue
+		}
+		for _, b := range packet {
+			port.steps = append(port.steps, serialReadStep{data: []byte{b}, after: func() { clock = clock.Add(gap) }})
+		}
+	}
+
+	timing := newRTUTiming(&serial.Config{BaudRate: 9600})
+	reader := newRTUReader(port, timing, newDiagnostics(nil), slog.Default())
+	reader.now = func() time.Time { return clock }
+
+	return reader, port
+}
+
+func readRTUFrames(t *testing.T, reader *rtuReader, reads int) []*RTUFrame {
+	t.Helper()
+
+	var frames []*RTUFrame
+	for range reads {
+		got, err := reader.read()
+		require.NoError(t, err)
+		frames = append(frames, got...)
+	}
+	return frames
+}
+
+func TestRTUReader(t *testing.T) {
+	readRequest := (&RTUFrame{Address: 1, Function: 3, Data: []byte{0x00, 0x00, 0x00, 0x02}}).Bytes()
+	writeRequest := (&RTUFrame{Address: 1, Function: 16, Data: []byte{0x00, 0x01, 0x00, 0x01, 0x02, 0x00, 0x07}}).Bytes()
+	customRequest := (&RTUFrame{Address: 1, Function: 0x41, Data: []byte{0x01, 0x02, 0x03}}).Bytes()
+
+	t.Run("delimits byte by byte frames by predicted length", func(t *testing.T) {
+		reader, _ := newTestRTUReader(100*time.Microsecond, readRequest, writeRequest)
+
+		frames := readRTUFrames(t, reader, len(readRequest))
+		require.Len(t, frames, 1)
+		assert.EqualValues(t, 3, frames[0].Function)
+
+		frames = readRTUFrames(t, reader, len(writeRequest))
+		require.Len(t, frames, 1)
+		assert.EqualValues(t, 16, frames[0].Function)
+		assert.Equal(t, []byte{0x00, 0x01, 0x00, 0x01, 0x02, 0x00, 0x07}, frames[0].Data)
+	})
+
+	t.Run("splits merged frames", func(t *testing.T) {
+		reader, port := newTestRTUReader(100 * time.Microsecond)
+		port.steps = []serialReadStep{{data: append(append([]byte{}, readRequest...), writeRequest...)}}
+
+		frames := readRTUFrames(t, reader, 1)
+		require.Len(t, frames, 2)
+		assert.EqualValues(t, 3, frames[0].Function)
+		assert.EqualValues(t, 16, frames[1].Function)
+	})
+
+	t.Run("ends unpredictable frames after t1.5 silence", func(t *testing.T) {
+		reader, _ := newTestRTUReader(time.Millisecond, customRequest, nil, nil)
+
+		frames := readRTUFrames(t, reader, len(customRequest))
+		assert.Empty(t, frames)
+
+		frames = readRTUFrames(t, reader, 2)
+		require.Len(t, frames, 1)
+		assert.EqualValues(t, 0x41, frames[0].Function)
+		assert.Equal(t, uint16(0), reader.diagnostics.Counters().BusCommunicationErrors)
+	})
+
+	t.Run("drops partial frame after t3.5 silence", func(t *testing.T) {
+		reader, port := newTestRTUReader(100 * time.Microsecond)
+		clock := time.Unix(0, 0)
+		reader.now = func() time.Time { return clock }
+		port.steps = []serialReadStep{
+			{data: readRequest[:4]},
+			{err: serial.ErrTimeout, after: func() { clock = clock.Add(5 * time.Millisecond) }},
+			{data: readRequest, after: func() { clock = clock.Add(time.Millisecond) }},
+		}
+
+		frames := readRTUFrames(t, reader, 3)
+		require.Len(t, frames, 1)
+		assert.EqualValues(t, 3, frames[0].Function)
+		assert.Equal(t, uint16(1), reader.diagnostics.Counters().BusCommunicationErrors)
+	})
+
+	t.Run("counts CRC error once the line is silent", func(t *testing.T) {
+		bad := append([]byte{}, readRequest...)
+		bad[len(bad)-1]++
+
+		reader, port := newTestRTUReader(100 * time.Microsecond)
+		clock := time.Unix(0, 0)
+		reader.now = func() time.Time { return clock }
+		port.steps = []serialReadStep{
+			{data: bad},
+			// Sent too early to be a new frame.
+			{data: readRequest, after: func() { clock = clock.Add(time.Millisecond) }},
+			{data: readRequest, after: func() { clock = clock.Add(10 * time.Millisecond) }},
+		}
+
+		frames := readRTUFrames(t, reader, 3)
+		require.Len(t, frames, 1)
+		assert.Equal(t, uint16(1), reader.diagnostics.Counters().BusCommunicationErrors)
+	})
+
+	t.Run("ends responses of other slaves after silence", func(t *testing.T) {
+		// Longer than the read request its function code predicts.
+		response := (&RTUFrame{Address: 2, Function: 3, Data: []byte{0x04, 0x00, 0x01, 0x00, 0x02}}).Bytes()
+
+		reader, _ := newTestRTUReader(time.Millisecond, response, nil, nil, readRequest)
+		reader.accept = func(address uint8) bool { return address == 1 }
+
+		frames := readRTUFrames(t, reader, len(response)+2+len(readRequest))
+		require.Len(t, frames, 2)
+		assert.EqualValues(t, 2, frames[0].Address)
+		assert.EqualValues(t, 1, frames[1].Address)
+		assert.Equal(t, uint16(0), reader.diagnostics.Counters().BusCommunicationErrors)
+	})
+
+	t.Run("ignores bad frames sent to other addresses", func(t *testing.T) {
+		bad := (&RTUFrame{Address: 2, Function: 3, Data: []byte{0x04, 0x00, 0x01, 0x00, 0x02}}).Bytes()
+		bad[len(bad)-1]++
+
+		reader, _ := newTestRTUReader(100*time.Microsecond, bad, nil, nil, nil, nil)
+		reader.accept = func(address uint8) bool { return address == 1 }
+
+		frames := readRTUFrames(t, reader, len(bad)+4)
+		assert.Empty(t, frames)
+		assert.Equal(t, uint16(0), reader.diagnostics.Counters().BusCommunicationErrors)
+	})
+
+	t.Run("counts overrun", func(t *testing.T) {
+		reader, port := newTestRTUReader(100 * time.Microsecond)
+		port.steps = []serialReadStep{{data: append([]byte{0x01, 0x41}, make([]byte, maxRTUFrameLength)...)}}
+
+		frames := readRTUFrames(t, reader, 1)
+		assert.Empty(t, frames)
+		assert.Equal(t, uint16(1), reader.diagnostics.Counters().BusCharacterOverruns)
+	})
+
+	t.Run("returns read error", func(t *testing.T) {
+		reader, port := newTestRTUReader(100 * time.Microsecond)
+		port.steps = []serialReadStep{{err: errors.New("read failure")}}
+
+		_, err := reader.read()
+		require.Error(t, err)
+	})
+}
+
+func TestServeRTU(t *testing.T) {
+	mr := NewMemRegister()
+	mr.HoldingRegisters[1] = 0x1234
+	s := NewServer(WithRegister(mr))
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	served := make(chan error)
+	go func() { served <- s.ServeRTU(server, 9600) }()
+
+	_, err := client.Write(rtuBytes(1, 3, []byte{0, 1, 0, 1}))
+	require.NoError(t, err)
+
+	expect := rtuBytes(1, 3, []byte{2, 0x12, 0x34})
+	response := make([]byte, len(expect))
+	_, err = io.ReadFull(client, response)
+	require.NoError(t, err)
+	assert.Equal(t, expect, response)
+
+	s.Shutdown(context.Background())
+
+	select {
+	case err := <-served:
+		assert.ErrorIs(t, err, ErrServerClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeRTU did not return after Shutdown")
+	}
+}
+
+func TestServeRTUEndOfStream(t *testing.T) {
+	s := NewServer()
+	t.Cleanup(func() { s.Close() })
+
+	client, server := net.Pipe()
+
+	served := make(chan error)
+	go func() { served <- s.ServeRTU(server, 9600) }()
+
+	client.Close()
+
+	select {
+	case err := <-served:
+		assert.ErrorIs(t, err, io.EOF)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeRTU did not return at the end of the stream")
+	}
+}
@@ -0,0 +1,100 @@
+package mbserver
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// rtuNetworkTiming delimits RTU frames carried over TCP, where bytes arrive in
+// bursts rather than at the pace of a serial line. Most frames end as soon as
+// their predicted length has been received.
+var rtuNetworkTiming = rtuTiming{t15: 50 * time.Millisecond, t35: time.Second}
+
+// ListenRTUOverTCP starts the Modbus server listening on "address:port" for
+// RTU frames, with CRC and without MBAP header, carried over TCP.
+func (s *Server) ListenRTUOverTCP(addressPort string) (err error) {
+	listen, err := net.Listen("tcp", addressPort)
+	if err != nil {
+		return err
+	}
+	s.rtuListeners = append(s.rtuListeners, listen)
+	return err
+}
+
+// ListenRTUOverUDP starts the Modbus server listening on "address:port" for
+// RTU frames carried in UDP datagrams, one frame per datagram.
+func (s *Server) ListenRTUOverUDP(addressPort string) (err error) {
+	conn, err := net.ListenPacket("udp", addressPort)
+	if err != nil {
+		return err
+	}
+	s.packetListeners = append(s.packetListeners, packetListener{
+		conn:      conn,
+		transport: TransportRTUOverUDP,
+		decode:    func(packet []byte) (Framer, error) { return NewRTUFrame(packet) },
+	})
+	return err
+}
+
+// serveRTUOverTCP reads RTU frames from the byte stream of the connection and
+// queues them as requests.
+func (s *Server) serveRTUOverTCP(c *connState) {
+	conn := c.conn
+
+	var inflight sync.WaitGroup
+	defer s.awaitResponses(&inflight)
+
+	window := make(chan struct{}, s.pipelineWindow)
+
+	reader := newRTUReader(conn, rtuNetworkTiming, nil, c.logger)
+	reader.frameErrors = func(err error) { s.metrics.frameError(TransportRTUOverTCP, "", err) }
+
+	for {
+		// Wake up early to end frames whose length cannot be predicted.
+		deadline := s.readDeadline(c)
+		if reader.inFrame() {
+			if frameEnd := time.Now().Add(rtuNetworkTiming.t15); deadline.IsZero() || frameEnd.Before(deadline) {
+				deadline = frameEnd
+			}
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return
+		}
+
+		select {
+		case <-s.closeSignalChan:
+			return
+		default:
+
+			n, err := conn.Read(reader.buffer)
+			if n > 0 {
+				c.touch()
+			}
+			frames := reader.feed(reader.buffer[:n], time.Now())
+
+			for _, frame := range frames {
+				request := &Request{
+					conn:       c,
+					frame:      frame,
+					transport:  TransportRTUOverTCP,
+					remoteAddr: conn.RemoteAddr(),
+					received:   time.Now(),
+				}
+
+				if !s.reserve(request, window) || !s.queue(request, &inflight) {
+					return
+				}
+			}
+
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() && !s.idle(c) {
+					continue
+				}
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package mbserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rtuBytes encodes an RTU frame with its CRC.
+func rtuBytes(address, function uint8, data []byte) []byte {
+	frame := &RTUFrame{Address: address, Function: function, Data: data}
+	return frame.Bytes()
+}
+
+func TestRTUOverTCP(t *testing.T) {
+	mr := NewMemRegister()
+	mr.HoldingRegisters[1] = 0x1234
+
+	s := NewServer(WithRegister(mr))
+	err := s.ListenRTUOverTCP("127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	go s.Start(context.Background())
+
+	conn, err := net.Dial("tcp", s.rtuListeners[0].Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	request := rtuBytes(1, 3, []byte{0, 1, 0, 1})
+	expect := rtuBytes(1, 3, []byte{2, 0x12, 0x34})
+
+	t.Run("Single write", func(t *testing.T) {
+		_, err := conn.Write(request)
+		require.NoError(t, err)
+
+		response := make([]byte, len(expect))
+		_, err = io.ReadFull(conn, response)
+		require.NoError(t, err)
+		assert.Equal(t, expect, response)
+	})
+
+	t.Run("Split writes", func(t *testing.T) {
+		_, err := conn.Write(request[:3])
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+		_, err = conn.Write(request[3:])
+		require.NoError(t, err)
+
+		response := make([]byte, len(expect))
+		_, err = io.ReadFull(conn, response)
+		require.NoError(t, err)
+		assert.Equal(t, expect, response)
+	})
+
+	t.Run("Two frames in one write", func(t *testing.T) {
+		_, err := conn.Write(append(append([]byte(nil), request...), request...))
+		require.NoError(t, err)
+
+		response := make([]byte, 2*len(expect))
+		_, err = io.ReadFull(conn, response)
+		require.NoError(t, err)
+		assert.Equal(t, append(append([]byte(nil), expect...), expect...), response)
+	})
+}
+
+func TestRTUOverUDP(t *testing.T) {
+	mr := NewMemRegister()
+	mr.HoldingRegisters[1] = 0x1234
+
+	s := NewServer(WithRegister(mr))
+	err := s.ListenRTUOverUDP("127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	go s.Start(context.Background())
+
+	conn, err := net.Dial("udp", s.packetListeners[0].conn.LocalAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	t.Run("Valid request", func(t *testing.T) {
+		_, err := conn.Write(rtuBytes(1, 3, []byte{0, 1, 0, 1}))
+		require.NoError(t, err)
+
+		response := make([]byte, 512)
+		n, err := conn.Read(response)
+		require.NoError(t, err)
+		assert.Equal(t, rtuBytes(1, 3, []byte{2, 0x12, 0x34}), response[:n])
+	})
+
+	t.Run("Bad CRC is dropped", func(t *testing.T) {
+		bad := rtuBytes(1, 3, []byte{0, 1, 0, 1})
+		bad[len(bad)-1] ^= 0xFF
+		_, err := conn.Write(bad)
+		require.NoError(t, err)
+
+		_, err = conn.Write(rtuBytes(1, 6, []byte{0, 2, 0, 7}))
+		require.NoError(t, err)
+
+		response := make([]byte, 512)
+		n, err := conn.Read(response)
+		require.NoError(t, err)
+		assert.Equal(t, rtuBytes(1, 6, []byte{0, 2, 0, 7}), response[:n])
+		assert.Equal(t, map[FrameErrorLabels]uint64{
+			{Transport: TransportRTUOverUDP, Kind: FrameErrorChecksum}: 1,
+		}, s.Metrics().Snapshot().FrameErrors)
+		assert.Equal(t, DiagnosticCounters{}, s.Diagnostics().Counters())
+	})
+}
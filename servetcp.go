@@ -5,12 +5,27 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"log/slog"
 	"net"
+	"sync"
 	"time"
 )
 
+// maxMBAPLength is the largest MBAP length field: the unit identifier and a
+// 253 byte PDU.
+const maxMBAPLength = 254
+
+// tcpResyncSilence is the silence ending the data discarded after a frame
+// error that leaves the stream out of sync.
+const tcpResyncSilence = 100 * time.Millisecond
+
 func (s *Server) accept(listen net.Listener) error {
+	return s.acceptConns(listen, TransportTCP, s.serveTCP)
+}
+
+// acceptConns accepts connections on listen and serves each of them with serve
+// in its own goroutine until the server is shut down. New connections are
+// subject to the limits set by WithMaxConns and WithMaxConnsPerIP.
+func (s *Server) acceptConns(listen net.Listener, transport Transport, serve func(*connState)) error {
 	defer listen.Close()
 
 	for {
@@ -34,83 +49,248 @@ func (s *Server) accept(listen net.Listener) error {
 				}
 			}
 
-			s.wg.Add(1)
-
-			go func(conn net.Conn) {
-				defer s.wg.Done()
-				defer conn.Close()
-
-				for {
-					select {
-					case <-s.closeSignalChan:
-						return
-					default:
-						if err := conn.SetReadDeadline(time.Now().Add(10 * time.Second)); err != nil {
-							return
-						}
-
-						header := make([]byte, 7)
-						for i := 0; i < 7; {
-							n, err := conn.Read(header[i:])
-							if err != nil {
-								var netErr net.Error
-								if errors.As(err, &netErr) && netErr.Timeout() {
-									select {
-									case <-s.closeSignalChan:
-										return
-									default:
-										continue
-									}
-								}
-								return
-							}
-							i += n
-						}
-
-						pduLength := binary.BigEndian.Uint16(header[4:6])
-						dataLength := pduLength - 1
-
-						packet := make([]byte, 7+dataLength)
-						copy(packet, header)
-						remaining := packet[7:]
-
-						for i := 0; i < int(dataLength); {
-							n, err := conn.Read(remaining[i:])
-							if err != nil {
-								var netErr net.Error
-								if errors.As(err, &netErr) && netErr.Timeout() {
-									select {
-									case <-s.closeSignalChan:
-										return
-									default:
-										continue
-									}
-								}
-								return
-							}
-							i += n
-						}
-
-						frame, err := NewTCPFrame(packet)
-						if err != nil {
-							slog.Error("failed to parse TCP frame", "error", err)
-
-							return
-						}
-
-						request := &Request{conn, frame}
-
-						select {
-						case s.requestChan <- request:
-						case <-s.closeSignalChan:
-							return
-						}
-					}
-				}
-			}(conn)
+			if !s.trackConn(conn) {
+				conn.Close()
+				continue
+			}
+			c, ok := s.admit(conn, transport)
+			if !ok {
+				s.releaseConn(conn)
+				conn.Close()
+				continue
+			}
+
+			go func() {
+				defer s.releaseConn(conn)
+
+				s.runTCPConn(c, serve)
+			}()
+		}
+
+	}
+}
+
+// serveTCP reads Modbus TCP frames from the connection and queues them as
+// requests, until the peer closes it, it has been idle for the idle timeout or
+// the server shuts down.
+func (s *Server) serveTCP(c *connState) {
+	conn := c.conn
+
+	var inflight sync.WaitGroup
+	defer s.awaitResponses(&inflight)
+
+	window := make(chan struct{}, s.pipelineWindow)
+
+	for {
+		frame, rejected, ok := s.readTCPFrame(c)
+		if !ok {
+			return
+		}
+		if frame == nil {
+			continue
+		}
+
+		request := &Request{
+			conn:       c,
+			frame:      frame,
+			transport:  c.transport,
+			remoteAddr: conn.RemoteAddr(),
+			received:   time.Now(),
+			rejected:   rejected,
+		}
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			request.tlsState = &state
+		}
+
+		if !s.reserve(request, window) || !s.queue(request, &inflight) {
+			return
+		}
+	}
+}
+
+// readTCPFrame reads the next MBAP frame from the connection. A malformed
+// frame is dropped, or returned with the exception to answer it with when its
+// function code is known; with WithStrictFraming it closes the connection
+// instead. readTCPFrame returns a nil frame for a dropped frame, and reports
+// false when the connection must be closed.
+func (s *Server) readTCPFrame(c *connState) (*TCPFrame, Exception, bool) {
+	header := make([]byte, 7)
+	if !s.readFull(c, header) {
+		return nil, Success, false
+	}
+
+	protocol := binary.BigEndian.Uint16(header[2:4])
+	length := binary.BigEndian.Uint16(header[4:6])
+
+	switch {
+	case length == 0:
+		// The unit identifier already read belongs to the next frame, if
+		// anything: the stream can no longer be trusted.
+		if !s.frameError(c, "MBAP length 0") {
+			return nil, Success, false
+		}
+		return nil, Success, s.resync(c)
+
+	case length == 1:
+		// The frame holds a unit identifier and no function code to answer.
+		return nil, Success, s.frameError(c, "MBAP length 1")
+
+	case length > maxMBAPLength:
+		if !s.frameError(c, "MBAP length over 254") {
+			return nil, Success, false
+		}
+
+		// The PDU is skipped to stay in sync with the stream, and answered
+		// after the function code it starts with.
+		funcCode := make([]byte, 1)
+		if !s.readFull(c, funcCode) || !s.skip(c, int(length)-2) {
+			return nil, Success, false
+		}
+		if protocol != 0 {
+			return nil, Success, true
+		}
+		frame := &TCPFrame{
+			TransactionIdentifier: binary.BigEndian.Uint16(header[0:2]),
+			Device:                header[6],
+			Function:              funcCode[0],
+		}
+		return frame, IllegalDataValue, true
+	}
+
+	packet := make([]byte, 6+int(length))
+	copy(packet, header)
+	if !s.readFull(c, packet[7:]) {
+		return nil, Success, false
+	}
+
+	frame, err := NewTCPFrame(packet)
+	if err != nil {
+		// The length being valid, only a non-zero protocol identifier is
+		// left: the frame is not a Modbus request and is not answered.
+		return nil, Success, s.frameError(c, err.Error())
+	}
+	return frame, Success, true
+}
+
+// frameError records a malformed MBAP frame and reports whether the
+// connection can be kept open.
+func (s *Server) frameError(c *connState, reason string) bool {
+	s.metrics.frameError(c.transport, "", errors.New(reason))
+
+	c.logger.Warn("bad MBAP frame", logKeyError, reason, "strict", s.strictFraming)
+
+	return !s.strictFraming
+}
+
+// skip reads and discards n bytes from the connection.
+func (s *Server) skip(c *connState, n int) bool {
+	buf := make([]byte, min(n, 512))
+	for n > 0 {
+		chunk := min(n, len(buf))
+		if !s.readFull(c, buf[:chunk]) {
+			return false
+		}
+		n -= chunk
+	}
+	return true
+}
+
+// resync discards the data received until the connection has been silent for
+// tcpResyncSilence, after which the next byte is taken as the start of a
+// frame. It reports false when the connection fails or the server shuts down.
+func (s *Server) resync(c *connState) bool {
+	buf := make([]byte, 512)
+	for {
+		if err := c.conn.SetReadDeadline(time.Now().Add(tcpResyncSilence)); err != nil {
+			return false
+		}
+		if s.closing() {
+			return false
+		}
+
+		n, err := c.conn.Read(buf)
+		if n > 0 {
+			c.touch()
+		}
+		if err != nil {
+			var netErr net.Error
+			return errors.As(err, &netErr) && netErr.Timeout() && !s.closing()
+		}
+	}
+}
+
+// readFull fills buf from the connection. It reports false when the
+// connection fails, times out for being idle or the server shuts down.
+func (s *Server) readFull(c *connState, buf []byte) bool {
+	for i := 0; i < len(buf); {
+		// The deadline is set before checking for shutdown, which interrupts
+		// reads by moving the deadline to the present.
+		if err := c.conn.SetReadDeadline(s.readDeadline(c)); err != nil {
+			return false
+		}
+		if s.closing() {
+			return false
+		}
+
+		n, err := c.conn.Read(buf[i:])
+		if n > 0 {
+			c.touch()
+			i += n
 		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && !s.closing() && !s.idle(c) {
+				continue
+			}
+			if s.idle(c) {
+				c.logger.Debug("closing idle connection")
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// Serve accepts Modbus TCP connections on listen, which may be a TCP, TLS or
+// Unix domain socket listener, and serves them until the server is shut down
+// or listen fails. The listener is closed when Serve returns. After Shutdown or
+// Close, Serve returns ErrServerClosed.
+func (s *Server) Serve(listen net.Listener) error {
+	if !s.trackListener(listen) {
+		listen.Close()
+		return ErrServerClosed
+	}
+	defer s.releaseListener(listen)
+
+	s.startHandler()
+
+	if err := s.acceptConns(listen, TransportTCP, s.serveTCP); err != nil {
+		return err
+	}
+	return ErrServerClosed
+}
+
+// ServeConn serves Modbus TCP requests on a single connection until it is
+// closed by the peer, times out for being idle or the server is shut down, in
+// which case ServeConn returns ErrServerClosed. The connection is closed when
+// ServeConn returns. It does not count in the connection limits.
+func (s *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	if !s.trackConn(conn) {
+		return ErrServerClosed
+	}
+	defer s.releaseConn(conn)
+
+	s.startHandler()
+
+	s.runTCPConn(s.newConnState(conn, TransportTCP), s.serveTCP)
 
+	if s.closing() {
+		return ErrServerClosed
 	}
+	return nil
 }
 
 // ListenTCP starts the Modbus server listening on "address:port".
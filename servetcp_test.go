@@ -1,6 +1,7 @@
 package mbserver
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -10,6 +11,7 @@ import (
 	"math/big"
 	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -109,7 +111,7 @@ func (e testTimeoutError) Temporary() bool { return true }
 
 func TestListenTCP(t *testing.T) {
 	s := NewServer()
-	t.Cleanup(s.Shutdown)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
 
 	err := s.ListenTCP("127.0.0.1:0")
 	require.NoError(t, err)
@@ -118,7 +120,7 @@ func TestListenTCP(t *testing.T) {
 
 func TestListenTLS(t *testing.T) {
 	s := NewServer()
-	t.Cleanup(s.Shutdown)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
 
 	err := s.ListenTLS("127.0.0.1:0", newTestTLSConfig(t))
 	require.NoError(t, err)
@@ -431,6 +433,73 @@ func TestAccept(t *testing.T) {
 	})
 }
 
+func TestServe(t *testing.T) {
+	mr := NewMemRegister()
+	mr.HoldingRegisters[1] = 0x1234
+	s := NewServer(WithRegister(mr))
+
+	listen, err := net.Listen("unix", t.TempDir()+"/mbserver.sock")
+	require.NoError(t, err)
+
+	served := make(chan error)
+	go func() { served <- s.Serve(listen) }()
+
+	conn, err := net.Dial("unix", listen.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 1, 0, 1})
+	require.NoError(t, err)
+
+	response := make([]byte, 11)
+	_, err = io.ReadFull(conn, response)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 0, 0, 0, 5, 1, 3, 2, 0x12, 0x34}, response)
+
+	conn.Close()
+	s.Shutdown(context.Background())
+
+	select {
+	case err := <-served:
+		assert.ErrorIs(t, err, ErrServerClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	mr := NewMemRegister()
+	mr.HoldingRegisters[1] = 0x1234
+	s := NewServer(WithRegister(mr))
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	served := make(chan error)
+	go func() { served <- s.ServeConn(server) }()
+
+	_, err := client.Write([]byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 1, 0, 1})
+	require.NoError(t, err)
+
+	response := make([]byte, 11)
+	_, err = io.ReadFull(client, response)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 0, 0, 0, 5, 1, 3, 2, 0x12, 0x34}, response)
+
+	// Shutdown interrupts the read ServeConn is blocked on.
+	s.Shutdown(context.Background())
+
+	select {
+	case err := <-served:
+		assert.ErrorIs(t, err, ErrServerClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeConn did not return after Shutdown")
+	}
+
+	assert.ErrorIs(t, s.ServeConn(server), ErrServerClosed, "ServeConn after Shutdown returns at once")
+}
+
 func newTestTLSConfig(t *testing.T) *tls.Config {
 	t.Helper()
 
@@ -456,3 +525,168 @@ func newTestTLSConfig(t *testing.T) *tls.Config {
 		}},
 	}
 }
+
+func TestMalformedMBAP(t *testing.T) {
+	valid := []byte{0x00, 0x09, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+
+	// readResponse reads a response of length bytes from conn.
+	readResponse := func(t *testing.T, conn net.Conn, length int) []byte {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		response := make([]byte, length)
+		_, err := io.ReadFull(conn, response)
+		require.NoError(t, err)
+		return response
+	}
+
+	tests := []struct {
+		name     string
+		frame    []byte
+		pause    time.Duration
+		response []byte
+	}{
+		{
+			name:     "answers oversized PDU with an exception",
+			frame:    append([]byte{0x00, 0x07, 0x00, 0x00, 0x01, 0x2C, 0x01, 0x10}, make([]byte, 298)...),
+			response: []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x03, 0x01, 0x90, byte(IllegalDataValue)},
+		},
+		{
+			name:  "drops non-zero protocol identifier",
+			frame: []byte{0x00, 0x07, 0x00, 0x01, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01},
+		},
+		{
+			name:  "drops frame without function code",
+			frame: []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x01, 0x01},
+		},
+		{
+			name:  "resynchronises after zero length",
+			frame: []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x00, 0x01, 0xAA, 0xBB, 0xCC},
+			pause: 2 * tcpResyncSilence,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewServer()
+			address := startTCPServer(t, s)
+
+			conn, err := net.Dial("tcp", address)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			_, err = conn.Write(test.frame)
+			require.NoError(t, err)
+			if test.response != nil {
+				assert.Equal(t, test.response, readResponse(t, conn, len(test.response)))
+			}
+			time.Sleep(test.pause)
+
+			// The connection is still in sync.
+			_, err = conn.Write(valid)
+			require.NoError(t, err)
+			response := readResponse(t, conn, 11)
+			assert.Equal(t, []byte{0x00, 0x09, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02}, response[:9])
+
+			assert.Equal(t, map[FrameErrorLabels]uint64{
+				{Transport: TransportTCP, Kind: FrameErrorFraming}: 1,
+			}, s.Metrics().Snapshot().FrameErrors)
+		})
+	}
+
+	t.Run("strict framing closes the connection", func(t *testing.T) {
+		s := NewServer(WithStrictFraming())
+		address := startTCPServer(t, s)
+
+		conn, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x00, 0x01})
+		require.NoError(t, err)
+		assert.True(t, closedByServer(conn))
+	})
+}
+
+func TestPipelining(t *testing.T) {
+	const requests = 100
+
+	tests := []struct {
+		name    string
+		options []OptionFunc
+	}{
+		{name: "serial", options: nil},
+		{name: "window of one", options: []OptionFunc{WithPipelineWindow(1)}},
+		{name: "per connection", options: []OptionFunc{WithConcurrency(ConcurrencyPerConnection)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			register := NewSyncMemRegister()
+			for i := range requests {
+				register.WriteSingleRegister(i, uint16(i*3))
+			}
+
+			s := NewServer(append(test.options, WithRegister(register))...)
+			address := startTCPServer(t, s)
+
+			conn, err := net.Dial("tcp", address)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			// All the requests are sent before reading any response.
+			var pipeline []byte
+			for i := range requests {
+				pipeline = append(pipeline, byte(i>>8), byte(0xA0+i), 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, byte(i), 0x00, 0x01)
+			}
+			_, err = conn.Write(pipeline)
+			require.NoError(t, err)
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for i := range requests {
+				response := make([]byte, 11)
+				_, err := io.ReadFull(conn, response)
+				require.NoError(t, err)
+
+				value := uint16(i * 3)
+				assert.Equal(t, []byte{byte(i >> 8), byte(0xA0 + i), 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, byte(value >> 8), byte(value)}, response)
+			}
+		})
+	}
+
+	t.Run("limits the requests read ahead", func(t *testing.T) {
+		const window = 3
+
+		var s *Server
+		var overflow atomic.Bool
+		s = NewServer(
+			WithPipelineWindow(window),
+			WithMiddleware(func(next Handler) Handler {
+				return HandlerFunc(func(ctx context.Context, frame Framer) ([]byte, Exception) {
+					// The request being handled holds a slot of the window.
+					if len(s.requestChan) > window-1 {
+						overflow.Store(true)
+					}
+					time.Sleep(time.Millisecond)
+					return next.ServeModbus(ctx, frame)
+				})
+			}),
+		)
+		address := startTCPServer(t, s)
+
+		conn, err := net.Dial("tcp", address)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		var pipeline []byte
+		for i := range 20 {
+			pipeline = append(pipeline, 0x00, byte(i), 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01)
+		}
+		_, err = conn.Write(pipeline)
+		require.NoError(t, err)
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = io.ReadFull(conn, make([]byte, 20*11))
+		require.NoError(t, err)
+		assert.False(t, overflow.Load())
+	})
+}
@@ -0,0 +1,100 @@
+package mbserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// packetListener is a datagram socket with the decoder of the frames it carries.
+type packetListener struct {
+	conn      net.PacketConn
+	transport Transport
+	decode    func(packet []byte) (Framer, error)
+}
+
+// packetConn sends the response to a request received on a packet listener
+// back to the peer the request came from.
+type packetConn struct {
+	conn net.PacketConn
+	addr net.Addr
+}
+
+func (c *packetConn) Read([]byte) (int, error) {
+	return 0, errors.New("read on a packet response writer")
+}
+
+func (c *packetConn) Write(b []byte) (int, error) {
+	return c.conn.WriteTo(b, c.addr)
+}
+
+// Close does nothing, the packet listener is shared by all peers.
+func (c *packetConn) Close() error {
+	return nil
+}
+
+// ListenUDP starts the Modbus server listening on "address:port" for Modbus
+// TCP frames, MBAP header included, carried in UDP datagrams.
+func (s *Server) ListenUDP(addressPort string) (err error) {
+	conn, err := net.ListenPacket("udp", addressPort)
+	if err != nil {
+		return err
+	}
+	s.packetListeners = append(s.packetListeners, packetListener{
+		conn:      conn,
+		transport: TransportUDP,
+		decode:    func(packet []byte) (Framer, error) { return NewTCPFrame(packet) },
+	})
+	return err
+}
+
+// acceptPackets decodes each datagram received by listener as one frame and
+// queues it as a request answered to the sender.
+func (s *Server) acceptPackets(listener packetListener) error {
+	defer listener.conn.Close()
+
+	var inflight sync.WaitGroup
+	defer s.awaitResponses(&inflight)
+
+	buffer := make([]byte, 512)
+
+	for {
+		select {
+		case <-s.closeSignalChan:
+			return nil
+		default:
+			n, addr, err := listener.conn.ReadFrom(buffer)
+			if err != nil {
+				select {
+				case <-s.closeSignalChan:
+					return nil
+				default:
+					return fmt.Errorf("unable to read datagrams: %w", err)
+				}
+			}
+
+			frame, err := listener.decode(append([]byte(nil), buffer[:n]...))
+			if err != nil {
+				s.metrics.frameError(listener.transport, "", err)
+
+				s.connLogger(listener.transport, addr).Warn("bad datagram frame", logKeyError, err)
+
+				continue
+			}
+
+			request := &Request{
+				conn:       &packetConn{conn: listener.conn, addr: addr},
+				frame:      frame,
+				transport:  listener.transport,
+				remoteAddr: addr,
+				received:   time.Now(),
+			}
+
+			if !s.queue(request, &inflight) {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package mbserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUDP(t *testing.T) {
+	mr := NewMemRegister()
+	mr.HoldingRegisters[1] = 0x1234
+
+	s := NewServer(WithRegister(mr))
+	err := s.ListenUDP("127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Start(context.Background())
+
+	conn, err := net.Dial("udp", s.packetListeners[0].conn.LocalAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	t.Run("Valid request", func(t *testing.T) {
+		_, err := conn.Write([]byte{0, 7, 0, 0, 0, 6, 1, 3, 0, 1, 0, 1})
+		require.NoError(t, err)
+
+		response := make([]byte, 512)
+		n, err := conn.Read(response)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0, 7, 0, 0, 0, 5, 1, 3, 2, 0x12, 0x34}, response[:n])
+	})
+
+	t.Run("Bad length is dropped", func(t *testing.T) {
+		_, err := conn.Write([]byte{0, 8, 0, 0, 0, 9, 1, 3, 0, 1, 0, 1})
+		require.NoError(t, err)
+		_, err = conn.Write([]byte{0, 9, 0, 0, 0, 6, 1, 6, 0, 2, 0, 7})
+		require.NoError(t, err)
+
+		response := make([]byte, 512)
+		n, err := conn.Read(response)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0, 9, 0, 0, 0, 6, 1, 6, 0, 2, 0, 7}, response[:n])
+	})
+
+	t.Run("Shutdown closes the socket", func(t *testing.T) {
+		s.Shutdown(context.Background())
+
+		_, _, err := s.packetListeners[0].conn.ReadFrom(make([]byte, 1))
+		assert.ErrorIs(t, err, net.ErrClosed)
+	})
+}
@@ -0,0 +1,130 @@
+package mbserver
+
+import "sync"
+
+// SyncMemRegister is an in-memory register safe for concurrent use, meant for
+// servers handling requests with ConcurrencyPerConnection. Each table has its
+// own RW lock: reads of a table run in parallel and only wait for writes to the
+// same table. Reads return copies of the table content.
+type SyncMemRegister struct {
+	mem *MemRegister
+
+	coils            sync.RWMutex
+	discreteInputs   sync.RWMutex
+	holdingRegisters sync.RWMutex
+	inputRegisters   sync.RWMutex
+}
+
+var (
+	_ Register     = (*SyncMemRegister)(nil)
+	_ MaskWriter   = (*SyncMemRegister)(nil)
+	_ FIFORegister = (*SyncMemRegister)(nil)
+)
+
+// NewSyncMemRegister returns a SyncMemRegister with 65536 entries in each table.
+func NewSyncMemRegister() *SyncMemRegister {
+	return &SyncMemRegister{mem: NewMemRegister()}
+}
+
+func (r *SyncMemRegister) ReadCoils(start, count int) ([]bool, Exception) {
+	r.coils.RLock()
+	defer r.coils.RUnlock()
+	values, exception := r.mem.ReadCoils(start, count)
+	return append([]bool(nil), values...), exception
+}
+
+func (r *SyncMemRegister) ReadDiscreteInputs(start, count int) ([]bool, Exception) {
+	r.discreteInputs.RLock()
+	defer r.discreteInputs.RUnlock()
+	values, exception := r.mem.ReadDiscreteInputs(start, count)
+	return append([]bool(nil), values...), exception
+}
+
+func (r *SyncMemRegister) ReadHoldingRegisters(start, count int) ([]uint16, Exception) {
+	r.holdingRegisters.RLock()
+	defer r.holdingRegisters.RUnlock()
+	values, exception := r.mem.ReadHoldingRegisters(start, count)
+	return append([]uint16(nil), values...), exception
+}
+
+func (r *SyncMemRegister) ReadInputRegisters(start, count int) ([]uint16, Exception) {
+	r.inputRegisters.RLock()
+	defer r.inputRegisters.RUnlock()
+	values, exception := r.mem.ReadInputRegisters(start, count)
+	return append([]uint16(nil), values...), exception
+}
+
+func (r *SyncMemRegister) WriteSingleCoil(start int, value bool) Exception {
+	r.coils.Lock()
+	defer r.coils.Unlock()
+	return r.mem.WriteSingleCoil(start, value)
+}
+
+func (r *SyncMemRegister) WriteSingleRegister(start int, value uint16) Exception {
+	r.holdingRegisters.Lock()
+	defer r.holdingRegisters.Unlock()
+	return r.mem.WriteSingleRegister(start, value)
+}
+
+func (r *SyncMemRegister) WriteMultipleCoils(start int, values []bool) Exception {
+	r.coils.Lock()
+	defer r.coils.Unlock()
+	return r.mem.WriteMultipleCoils(start, values)
+}
+
+func (r *SyncMemRegister) WriteMultipleRegisters(start int, values []uint16) Exception {
+	r.holdingRegisters.Lock()
+	defer r.holdingRegisters.Unlock()
+	return r.mem.WriteMultipleRegisters(start, values)
+}
+
+// MaskWriteRegister applies a Mask Write Register to a holding register while
+// holding the holding registers lock.
+func (r *SyncMemRegister) MaskWriteRegister(address int, andMask, orMask uint16) Exception {
+	r.holdingRegisters.Lock()
+	defer r.holdingRegisters.Unlock()
+	return r.mem.MaskWriteRegister(address, andMask, orMask)
+}
+
+// WriteDiscreteInputs sets discrete inputs, which Modbus clients can only read.
+func (r *SyncMemRegister) WriteDiscreteInputs(start int, values []bool) Exception {
+	r.discreteInputs.Lock()
+	defer r.discreteInputs.Unlock()
+	if start+len(values) > len(r.mem.DiscreteInputs) {
+		return IllegalDataAddress
+	}
+	copy(r.mem.DiscreteInputs[start:], values)
+	return Success
+}
+
+// WriteInputRegisters sets input registers, which Modbus clients can only read.
+func (r *SyncMemRegister) WriteInputRegisters(start int, values []uint16) Exception {
+	r.inputRegisters.Lock()
+	defer r.inputRegisters.Unlock()
+	if start+len(values) > len(r.mem.InputRegisters) {
+		return IllegalDataAddress
+	}
+	copy(r.mem.InputRegisters[start:], values)
+	return Success
+}
+
+// DeclareFIFO declares an empty FIFO queue at the FIFO pointer address.
+func (r *SyncMemRegister) DeclareFIFO(address int) {
+	r.mem.DeclareFIFO(address)
+}
+
+// PushFIFO appends values to the FIFO queue declared at address.
+func (r *SyncMemRegister) PushFIFO(address int, values ...uint16) Exception {
+	return r.mem.PushFIFO(address, values...)
+}
+
+// PopFIFO removes and returns the oldest value of the FIFO queue declared at
+// address. It reports false when the queue is empty or not declared.
+func (r *SyncMemRegister) PopFIFO(address int) (uint16, bool) {
+	return r.mem.PopFIFO(address)
+}
+
+// ReadFIFOQueue returns the content of the FIFO queue declared at address.
+func (r *SyncMemRegister) ReadFIFOQueue(address int) ([]uint16, Exception) {
+	return r.mem.ReadFIFOQueue(address)
+}
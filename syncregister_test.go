@@ -0,0 +1,88 @@
+package mbserver
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncMemRegister(t *testing.T) {
+	t.Run("reads return copies", func(t *testing.T) {
+		r := NewSyncMemRegister()
+		require.Equal(t, Success, r.WriteMultipleRegisters(0, []uint16{1, 2}))
+
+		values, exc := r.ReadHoldingRegisters(0, 2)
+		require.Equal(t, Success, exc)
+		values[0] = 9
+
+		values, _ = r.ReadHoldingRegisters(0, 2)
+		assert.Equal(t, []uint16{1, 2}, values)
+	})
+
+	t.Run("writes input tables", func(t *testing.T) {
+		r := NewSyncMemRegister()
+		require.Equal(t, Success, r.WriteDiscreteInputs(3, []bool{true}))
+		require.Equal(t, Success, r.WriteInputRegisters(3, []uint16{7}))
+
+		inputs, _ := r.ReadDiscreteInputs(3, 1)
+		assert.Equal(t, []bool{true}, inputs)
+		registers, _ := r.ReadInputRegisters(3, 1)
+		assert.Equal(t, []uint16{7}, registers)
+
+		assert.Equal(t, IllegalDataAddress, r.WriteDiscreteInputs(65535, []bool{true, true}))
+		assert.Equal(t, IllegalDataAddress, r.WriteInputRegisters(65535, []uint16{1, 2}))
+	})
+
+	t.Run("out of bounds", func(t *testing.T) {
+		r := NewSyncMemRegister()
+
+		_, exc := r.ReadCoils(65535, 2)
+		assert.Equal(t, IllegalDataAddress, exc)
+		assert.Equal(t, IllegalDataAddress, r.WriteSingleRegister(65536, 1))
+		assert.Equal(t, IllegalDataAddress, r.MaskWriteRegister(65536, 0, 0))
+	})
+
+	t.Run("FIFO", func(t *testing.T) {
+		r := NewSyncMemRegister()
+		r.DeclareFIFO(10)
+		require.Equal(t, Success, r.PushFIFO(10, 1, 2))
+
+		queue, exc := r.ReadFIFOQueue(10)
+		require.Equal(t, Success, exc)
+		assert.Equal(t, []uint16{1, 2}, queue)
+
+		value, ok := r.PopFIFO(10)
+		assert.True(t, ok)
+		assert.Equal(t, uint16(1), value)
+	})
+
+	t.Run("concurrent access", func(t *testing.T) {
+		r := NewSyncMemRegister()
+
+		var wg sync.WaitGroup
+		for i := range 8 {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for range 100 {
+					r.WriteMultipleRegisters(0, []uint16{uint16(i), uint16(i)})
+					r.WriteSingleCoil(i, true)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for range 100 {
+					values, _ := r.ReadHoldingRegisters(0, 2)
+					assert.Equal(t, values[0], values[1])
+					r.ReadCoils(0, 8)
+				}
+			}()
+		}
+		wg.Wait()
+
+		coils, _ := r.ReadCoils(0, 8)
+		assert.Equal(t, []bool{true, true, true, true, true, true, true, true}, coils)
+	})
+}
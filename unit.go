@@ -0,0 +1,119 @@
+package mbserver
+
+import "context"
+
+// unit is a slave hosted behind the server, with its own register and function
+// handlers that take precedence over the server function table.
+type unit struct {
+	register Register
+	function [256]FunctionHandler
+}
+
+// UnitOptionFunc is a function type used to configure a unit added with WithUnit.
+type UnitOptionFunc func(u *unit)
+
+// WithUnit hosts an independent slave with its own register behind the server.
+// Once a unit is added requests are routed by their unit identifier (the RTU
+// address or the MBAP unit identifier): requests for units that are not hosted
+// are dropped on serial lines and answered with the unknown unit exception on
+// TCP. A nil register is replaced by a new register of the same kind as the
+// default one: a SyncMemRegister under ConcurrencyPerConnection, a MemRegister
+// otherwise.
+func WithUnit(unitID uint8, register Register, opts ...UnitOptionFunc) OptionFunc {
+	return func(s *Server) {
+		// Created by NewServer once every option, the concurrency mode
+		// included, has been applied.
+		u := &unit{register: register}
+		for _, opt := range opts {
+			opt(u)
+		}
+
+		s.units[unitID] = u
+		s.routing = true
+	}
+}
+
+// WithUnitFunction registers a function handler used only by the unit.
+func WithUnitFunction(funcCode uint8, function Function) UnitOptionFunc {
+	return func(u *unit) {
+		u.function[funcCode] = AdaptFunction(function)
+	}
+}
+
+// WithUnknownUnitException sets the exception returned on TCP for requests
+// addressed to a unit that is not hosted. Success drops those requests without
+// a response. The default is GatewayTargetDeviceFailedToRespond.
+func WithUnknownUnitException(exception Exception) OptionFunc {
+	return func(s *Server) {
+		s.unknownUnitException = exception
+	}
+}
+
+// unit returns the unit a request is addressed to, or nil when it is not hosted.
+func (s *Server) unit(address uint8) *unit {
+	if !s.routing {
+		return &s.defaultUnit
+	}
+	return s.units[address]
+}
+
+// servesAddress reports whether requests sent to a serial line address are
+// served, hosted units and broadcasts being.
+func (s *Server) servesAddress(address uint8) bool {
+	return address == 0 || s.unit(address) != nil
+}
+
+// isBroadcastFunction reports whether a broadcast request with funcCode is
+// executed: the write functions 5, 6, 15, 16, 21, 22 and 23.
+func isBroadcastFunction(funcCode uint8) bool {
+	switch funcCode {
+	case 5, 6, 15, 16, 21, 22, 23:
+		return true
+	}
+	return false
+}
+
+// broadcastUnits returns the units that execute a broadcast request.
+func (s *Server) broadcastUnits() []*unit {
+	if !s.routing {
+		return []*unit{&s.defaultUnit}
+	}
+
+	var units []*unit
+	for _, u := range s.units {
+		if u != nil {
+			units = append(units, u)
+		}
+	}
+	return units
+}
+
+// call runs the function handler of u for frame and returns the response it built.
+func (s *Server) call(ctx context.Context, u *unit, diagnostics *Diagnostics, frame Framer) *response {
+	funcCode := frame.GetFunction()
+
+	handler := u.function[funcCode]
+	if handler == nil {
+		handler = s.function[funcCode]
+	}
+	if handler == nil {
+		handler = AdaptFunction(diagnostics.function(funcCode))
+	}
+
+	w := &response{}
+	if handler == nil {
+		w.exception = IllegalFunction
+		return w
+	}
+
+	info, _ := RequestInfoFromContext(ctx)
+	handler(w, &FunctionRequest{
+		RequestInfo: info,
+		Frame:       frame,
+		Register:    u.register,
+		FileStore:   s.fileStore,
+		Diagnostics: diagnostics,
+		ctx:         ctx,
+	})
+	return w
+}
@@ -0,0 +1,170 @@
+package mbserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRTUFrame(address, function uint8) *RTUFrame {
+	return &RTUFrame{
+		Address:  address,
+		Function: function,
+	}
+}
+
+func TestUnitRouting(t *testing.T) {
+	mr1 := NewMemRegister()
+	mr2 := NewMemRegister()
+	mr1.HoldingRegisters[0] = 1
+	mr2.HoldingRegisters[0] = 2
+
+	s := NewServer(WithUnit(1, mr1), WithUnit(2, mr2))
+
+	for unitID, expect := range map[uint8][]byte{1: {2, 0, 1}, 2: {2, 0, 2}} {
+		frame := newTestTCPFrame(3)
+		frame.Device = unitID
+		SetDataWithRegisterAndNumber(frame, 0, 1)
+
+		response := s.handle(&Request{frame: frame})
+		assertSuccess(t, response)
+		assert.Equal(t, expect, response.GetData())
+	}
+}
+
+func TestUnitDefaultRegister(t *testing.T) {
+	s := NewServer(WithUnit(1, nil))
+	assert.IsType(t, &MemRegister{}, s.units[1].register)
+
+	// The concurrency mode applies whatever the order of the options.
+	s = NewServer(WithUnit(1, nil), WithConcurrency(ConcurrencyPerConnection))
+	assert.IsType(t, &SyncMemRegister{}, s.units[1].register)
+	assert.IsType(t, &SyncMemRegister{}, s.register)
+}
+
+func TestUnitFunction(t *testing.T) {
+	custom := func(Register, Framer) ([]byte, Exception) {
+		return []byte{0x42}, Success
+	}
+	s := NewServer(WithUnit(1, nil, WithUnitFunction(0x41, custom)), WithUnit(2, nil))
+
+	frame := newTestTCPFrame(0x41)
+	frame.Device = 1
+	response := s.handle(&Request{frame: frame})
+	assertSuccess(t, response)
+	assert.Equal(t, []byte{0x42}, response.GetData())
+
+	frame = newTestTCPFrame(0x41)
+	frame.Device = 2
+	response = s.handle(&Request{frame: frame})
+	assert.Equal(t, IllegalFunction, GetException(response))
+}
+
+func TestUnknownUnit(t *testing.T) {
+	t.Run("TCP default exception", func(t *testing.T) {
+		s := NewServer(WithUnit(1, nil))
+
+		frame := newTestTCPFrame(3)
+		frame.Device = 9
+		SetDataWithRegisterAndNumber(frame, 0, 1)
+
+		response := s.handle(&Request{frame: frame})
+		require.NotNil(t, response)
+		assert.Equal(t, GatewayTargetDeviceFailedToRespond, GetException(response))
+	})
+
+	t.Run("TCP configured exception", func(t *testing.T) {
+		s := NewServer(WithUnit(1, nil), WithUnknownUnitException(GatewayPathUnavailable))
+
+		frame := newTestTCPFrame(3)
+		frame.Device = 9
+		SetDataWithRegisterAndNumber(frame, 0, 1)
+
+		response := s.handle(&Request{frame: frame})
+		require.NotNil(t, response)
+		assert.Equal(t, GatewayPathUnavailable, GetException(response))
+	})
+
+	t.Run("TCP dropped", func(t *testing.T) {
+		s := NewServer(WithUnit(1, nil), WithUnknownUnitException(Success))
+
+		frame := newTestTCPFrame(3)
+		frame.Device = 9
+		SetDataWithRegisterAndNumber(frame, 0, 1)
+
+		assert.Nil(t, s.handle(&Request{frame: frame}))
+	})
+
+	t.Run("RTU dropped", func(t *testing.T) {
+		s := NewServer(WithUnit(1, nil))
+
+		frame := newTestRTUFrame(9, 3)
+		SetDataWithRegisterAndNumber(frame, 0, 1)
+
+		assert.Nil(t, s.handle(&Request{frame: frame, diagnostics: newDiagnostics(s.diagnostics)}))
+		assert.Equal(t, uint16(1), s.Diagnostics().Counters().BusMessages)
+		assert.Equal(t, uint16(0), s.Diagnostics().Counters().ServerMessages)
+	})
+}
+
+func TestWithoutUnitsAnswersEveryAddress(t *testing.T) {
+	s := NewServer()
+
+	frame := newTestRTUFrame(9, 3)
+	SetDataWithRegisterAndNumber(frame, 0, 1)
+
+	response := s.handle(&Request{frame: frame})
+	require.NotNil(t, response)
+	assertSuccess(t, response)
+}
+
+func TestBroadcast(t *testing.T) {
+	t.Run("without units", func(t *testing.T) {
+		mr := NewMemRegister()
+		s := NewServer(WithRegister(mr))
+
+		frame := newTestRTUFrame(0, 6)
+		SetDataWithRegisterAndNumber(frame, 3, 7)
+
+		assert.Nil(t, s.handle(&Request{frame: frame}))
+		assert.Equal(t, uint16(7), mr.HoldingRegisters[3])
+	})
+
+	t.Run("with units", func(t *testing.T) {
+		mr1 := NewMemRegister()
+		mr2 := NewMemRegister()
+		s := NewServer(WithUnit(1, mr1), WithUnit(2, mr2))
+
+		frame := newTestRTUFrame(0, 6)
+		SetDataWithRegisterAndNumber(frame, 3, 7)
+
+		assert.Nil(t, s.handle(&Request{frame: frame, diagnostics: newDiagnostics(s.diagnostics)}))
+		assert.Equal(t, uint16(7), mr1.HoldingRegisters[3])
+		assert.Equal(t, uint16(7), mr2.HoldingRegisters[3])
+		assert.Equal(t, uint16(1), s.Diagnostics().Counters().ServerNoResponses)
+	})
+
+	t.Run("TCP unit 0 is not a broadcast", func(t *testing.T) {
+		s := NewServer()
+
+		frame := newTestTCPFrame(6)
+		frame.Device = 0
+		SetDataWithRegisterAndNumber(frame, 3, 7)
+
+		assertSuccess(t, s.handle(&Request{frame: frame}))
+	})
+
+	t.Run("only writes are executed", func(t *testing.T) {
+		var called bool
+		s := NewServer(WithFunctionHandler(3, func(w ResponseWriter, r *FunctionRequest) {
+			called = true
+		}))
+
+		frame := newTestRTUFrame(0, 3)
+		SetDataWithRegisterAndNumber(frame, 0, 1)
+
+		assert.Nil(t, s.handle(&Request{frame: frame}))
+		assert.False(t, called)
+	})
+}
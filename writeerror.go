@@ -0,0 +1,86 @@
+package mbserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// defaultWriteTimeout bounds the time a response may take to be written to a
+// TCP connection when WithWriteTimeout is not used.
+const defaultWriteTimeout = 5 * time.Second
+
+// WriteError reports a response that could not be written, completely, to
+// the client.
+type WriteError struct {
+	RemoteAddr net.Addr
+	Transport  Transport
+	Err        error
+}
+
+func (e *WriteError) Error() string {
+	if e.RemoteAddr == nil {
+		return fmt.Sprintf("mbserver: failed to write %s response: %v", e.Transport, e.Err)
+	}
+	return fmt.Sprintf("mbserver: failed to write %s response to %s: %v", e.Transport, e.RemoteAddr, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// WithWriteTimeout sets the time a response may take to be written to a TCP
+// connection before the connection is given up, so that a client that stops
+// reading cannot hold up the other clients. Zero disables the timeout. The
+// default is five seconds.
+func WithWriteTimeout(d time.Duration) OptionFunc {
+	return func(s *Server) {
+		s.writeTimeout = d
+	}
+}
+
+// WithErrorHandler sets a function called with the responses that could not
+// be written, as a *WriteError. Without it they are logged.
+func WithErrorHandler(fn func(err error)) OptionFunc {
+	return func(s *Server) {
+		s.errorHandler = fn
+	}
+}
+
+// write writes a response to the connection of request, a short write being
+// an error.
+func (s *Server) write(request *Request, response []byte) error {
+	n, err := request.conn.Write(response)
+	if err == nil && n < len(response) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// writeFailed reports a response that could not be written. A TCP connection
+// is closed, as the stream can no longer be trusted; serial ports and UDP
+// sockets are kept open.
+func (s *Server) writeFailed(request *Request, err error) {
+	if c, ok := request.conn.(*connState); ok {
+		// Report a connection once: the responses queued after the failure
+		// fail too.
+		if !c.broken.CompareAndSwap(false, true) {
+			return
+		}
+		c.Close()
+	}
+
+	// Connections closed on purpose by Close are not worth reporting.
+	if errors.Is(err, net.ErrClosed) && s.closing() {
+		return
+	}
+
+	writeErr := &WriteError{RemoteAddr: request.remoteAddr, Transport: request.transport, Err: err}
+	if s.errorHandler != nil {
+		s.errorHandler(writeErr)
+		return
+	}
+	s.logger.Warn("failed to write response", append(requestAttrs(request), logKeyError, err)...)
+}
@@ -0,0 +1,119 @@
+package mbserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingWriteConn is a connection whose writes return n and err.
+type failingWriteConn struct {
+	net.Conn
+	n   int
+	err error
+}
+
+func (c *failingWriteConn) Write([]byte) (int, error) {
+	return c.n, c.err
+}
+
+func TestWriteErrors(t *testing.T) {
+	request := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	errReset := errors.New("connection reset by peer")
+
+	tests := []struct {
+		name string
+		n    int
+		err  error
+		want error
+	}{
+		{name: "write error", err: errReset, want: errReset},
+		{name: "short write", n: 3, want: io.ErrShortWrite},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := make(chan error, 10)
+			s := NewServer(WithErrorHandler(func(err error) { errs <- err }))
+			t.Cleanup(func() { s.Close() })
+
+			client, server := net.Pipe()
+			defer client.Close()
+
+			served := make(chan error, 1)
+			go func() { served <- s.ServeConn(&failingWriteConn{Conn: server, n: test.n, err: test.err}) }()
+
+			_, err := client.Write(request)
+			require.NoError(t, err)
+
+			select {
+			case err := <-errs:
+				var writeErr *WriteError
+				require.ErrorAs(t, err, &writeErr)
+				assert.ErrorIs(t, err, test.want)
+				assert.Equal(t, TransportTCP, writeErr.Transport)
+			case <-time.After(time.Second):
+				t.Fatal("write error not reported")
+			}
+
+			// The connection is closed.
+			select {
+			case err := <-served:
+				assert.NoError(t, err)
+			case <-time.After(time.Second):
+				t.Fatal("connection not closed")
+			}
+			assert.Empty(t, errs)
+		})
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	request := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+
+	errs := make(chan error, 10)
+	s := NewServer(
+		WithWriteTimeout(50*time.Millisecond),
+		WithErrorHandler(func(err error) { errs <- err }),
+	)
+	t.Cleanup(func() { s.Close() })
+
+	// The stuck client sends requests and never reads the responses.
+	stuck, stuckServer := net.Pipe()
+	defer stuck.Close()
+	go s.ServeConn(stuckServer)
+	go func() {
+		for range 3 {
+			if _, err := stuck.Write(request); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("write timeout not reported")
+	}
+
+	// The handler is free to answer other clients.
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.ServeConn(server)
+
+	client.SetDeadline(time.Now().Add(time.Second))
+	_, err := client.Write(request)
+	require.NoError(t, err)
+	_, err = io.ReadFull(client, make([]byte, 11))
+	require.NoError(t, err)
+
+	require.NoError(t, s.Shutdown(context.Background()))
+}